@@ -0,0 +1,139 @@
+package address
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func sequence(from, to int) []byte {
+	result := make([]byte, 0, to-from)
+	for i := from; i < to; i++ {
+		result = append(result, byte(i))
+	}
+	return result
+}
+
+func TestChecksums(t *testing.T) {
+	tests := []struct {
+		s        string
+		checksum uint32
+	}{
+		{"a12uel5l", bech32Const},
+		{"abcdef1qpzry9x8gf2tvdw0s3jn54khce6mua7lmqqqxw", bech32Const},
+		{"split1checkupstagehandshakeupstreamerranterredcaperred2y9e3w", bech32Const},
+		{"a1lqfn3a", bech32mConst},
+		{"abcdef1l7aum6echk45nj3s0wdvt2fg8x9yrzpqzd3ryx", bech32mConst},
+		{"split1checkupstagehandshakeupstreamerranterredcaperredlc445v", bech32mConst},
+	}
+	for _, test := range tests {
+		if _, _, err := decode(test.s, test.checksum); err != nil {
+			t.Errorf("Expected %s to be valid: %v", test.s, err)
+		}
+		other := uint32(bech32Const)
+		if test.checksum == bech32Const {
+			other = bech32mConst
+		}
+		if _, _, err := decode(test.s, other); err == nil {
+			t.Errorf("Expected %s to be rejected with other checksum", test.s)
+		}
+	}
+	if _, _, err := decode("A12uEL5L", bech32Const); err == nil {
+		t.Error("Expected mixed case string to be rejected")
+	}
+}
+
+func TestF4Jumble(t *testing.T) {
+	tests := []struct {
+		message []byte
+		want    string
+	}{
+		{sequence(0, 48), "ad89bfac63c78b1cc325661c40cc56b291cf50be748dba7bc0b74851fc87ac797da311647be438dcd8df735a3361a8d1"},
+		{sequence(0, 200), "b23b9554c2ac6e0222c9546061472c0d67a83a782d4cbe7c7564cd5068adf74056036dabf15136f739a0703313c9888c34b51550424912a93fac0b0c87dbba19cbe304296511b8b26e4db1033c24e207e78de0834e17f41bd303cbfe6c70a306ed5a8e5fa88450779776cd0a5c651abfffcb49cf25db47a80ac1c6b80ecf963f2a33038fd0add7240185a86c6ddb422493fe3e3d1a3f7a5e0e10886d638ff606a477aaaa01ea0ab6fa1baac3787d525a596f820c4f46b99e71ae3d7d117e5849aedd9ffcc16bbc55"},
+	}
+	for _, test := range tests {
+		jumbled, err := F4Jumble(test.message)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hex.EncodeToString(jumbled) != test.want {
+			t.Errorf("Unexpected F4Jumble of %d bytes: %x", len(test.message), jumbled)
+		}
+		message, err := F4JumbleInv(jumbled)
+		if err != nil || !bytes.Equal(message, test.message) {
+			t.Errorf("Expected F4JumbleInv to restore %d bytes message: %v", len(test.message), err)
+		}
+	}
+	if _, err := F4Jumble(sequence(0, 47)); err == nil {
+		t.Error("Expected too short message to be rejected")
+	}
+}
+
+func TestDecodeSapling(t *testing.T) {
+	data, err := DecodeSapling("zs1qqqsyqcyq5rqwzqfpg9scrgwpugpzysnzs23v9ccrydpk8qarc0jqgfzyvjz2f389q5j5ctfvp5", "zs")
+	if err != nil || !bytes.Equal(data, sequence(0, 43)) {
+		t.Errorf("Unexpected Sapling address data %x: %v", data, err)
+	}
+	if _, err := DecodeSapling("ztestsapling1kg3u0y7szv6509732at34alct46cyn0g26kppgf2a7h5tpqxldtwm7cmhf8rqmhgtmpakcz5mdv", "zs"); err == nil {
+		t.Error("Expected testnet address to be rejected on mainnet")
+	}
+	if _, err := DecodeSapling("u10yhrumcnw293e5qhkflnaf5hwvksk42pqxmv4622eesqckqw2r4td2qx7zdk2h6azl9m4gekr70tmeztpmhh9vurcg9sut9umsd7e8hp", "u"); err == nil {
+		t.Error("Expected unified address to be rejected as Sapling address")
+	}
+}
+
+func TestDecodeUnified(t *testing.T) {
+	receivers, err := DecodeUnified("u10yhrumcnw293e5qhkflnaf5hwvksk42pqxmv4622eesqckqw2r4td2qx7zdk2h6azl9m4gekr70tmeztpmhh9vurcg9sut9umsd7e8hp", "u")
+	if err != nil {
+		t.Fatalf("Expected unified address to be valid: %v", err)
+	}
+	if len(receivers) != 1 || receivers[0].Typecode != TypeSapling || !bytes.Equal(receivers[0].Data, sequence(0, 43)) {
+		t.Errorf("Unexpected receivers %v", receivers)
+	}
+
+	testAddress := "utest1cvanrhfp7hhjq2nymm7fatse8tv2kn96leewnsqrrcmj8p6g6chuua39wjhx9exxdlga3zhjkqztl6asxnqjc34txwk47cw299w8n05k9dpxhts2wvvpqa97tqzz08c337y80pjuhrjlqz49tnapgc57y0w09vepg0n0zsjjlhpu2l8arq8hhuyduf5s7fjs74z52nlufmfzgy3rk03"
+	receivers, err = DecodeUnified(testAddress, "utest")
+	if err != nil {
+		t.Fatalf("Expected testnet unified address to be valid: %v", err)
+	}
+	want := []Receiver{
+		{TypeP2PKH, sequence(200, 220)},
+		{TypeSapling, sequence(0, 43)},
+		{TypeOrchard, sequence(100, 143)},
+	}
+	if len(receivers) != len(want) {
+		t.Fatalf("Unexpected receivers %v", receivers)
+	}
+	for i := range want {
+		if receivers[i].Typecode != want[i].Typecode || !bytes.Equal(receivers[i].Data, want[i].Data) {
+			t.Errorf("Unexpected receiver %v, want %v", receivers[i], want[i])
+		}
+	}
+	if _, err := DecodeUnified(testAddress, "u"); err == nil {
+		t.Error("Expected testnet address to be rejected on mainnet")
+	}
+
+	invalid := []string{
+		// Transparent receiver only
+		"u1zt8jctg466z3sa68p60yc332fyhg6zkg6e5d4c9he7xytx9ujvhs99rvt7qcxgmmgvg",
+		// Orchard receiver before Sapling one
+		"u12wuvwaea8g7swmj2x86d7hcftgta9ulqt0slr7lepxhg9upxpe7gun92ssvvx5edyv2rzqxsyv7d8j3qh454wms27vncrqy87xawppzyznev07fclh42yhrrr7c9te3phm5g0kqev5le0r4v22qnlwv9hzsy8t67nm49qrs9mu379f5e",
+		// Sapling address
+		"zs1qqqsyqcyq5rqwzqfpg9scrgwpugpzysnzs23v9ccrydpk8qarc0jqgfzyvjz2f389q5j5ctfvp5",
+	}
+	for _, s := range invalid {
+		if _, err := DecodeUnified(s, "u"); err == nil {
+			t.Errorf("Expected %s to be rejected", s)
+		}
+	}
+}
+
+func TestDecodeBase58Check(t *testing.T) {
+	decoded, err := DecodeBase58Check("tmGoHHqgsCRuEna9YQX9zKp9ujeqGLMLEYi")
+	if err != nil || len(decoded) != 22 || !bytes.Equal(decoded[:2], []byte{0x1d, 0x25}) {
+		t.Errorf("Unexpected decoded testnet address %x: %v", decoded, err)
+	}
+	if _, err := DecodeBase58Check("tmGoHHqgsCRuEna9YQX9zKp9ujeqGLMLEYj"); err == nil {
+		t.Error("Expected address with bad checksum to be rejected")
+	}
+}
@@ -0,0 +1,26 @@
+// Package address decodes Zcash transparent, Sapling and unified addresses
+package address
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+
+	"github.com/btcsuite/btcutil/base58"
+)
+
+// Returns version prefix and hash of base58check string, checksum stripped
+func DecodeBase58Check(s string) ([]byte, error) {
+	decoded := base58.Decode(s)
+	if len(decoded) < 5 {
+		return nil, errors.New("malformed address")
+	}
+
+	payloadLen := len(decoded) - 4
+	first := sha256.Sum256(decoded[:payloadLen])
+	checksum := sha256.Sum256(first[:])
+	if !bytes.Equal(checksum[:4], decoded[payloadLen:]) {
+		return nil, errors.New("bad address checksum")
+	}
+	return decoded[:payloadLen], nil
+}
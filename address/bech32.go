@@ -0,0 +1,114 @@
+package address
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+var generator = [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+
+// Checksum constants of BIP 173 and BIP 350
+const (
+	bech32Const  = 1
+	bech32mConst = 0x2bc830a3
+)
+
+func polymod(values []byte) uint32 {
+	chk := uint32(1)
+	for _, v := range values {
+		b := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if (b>>uint(i))&1 == 1 {
+				chk ^= generator[i]
+			}
+		}
+	}
+	return chk
+}
+
+func hrpExpand(hrp string) []byte {
+	result := make([]byte, 0, len(hrp)*2+1)
+	for i := 0; i < len(hrp); i++ {
+		result = append(result, hrp[i]>>5)
+	}
+	result = append(result, 0)
+	for i := 0; i < len(hrp); i++ {
+		result = append(result, hrp[i]&31)
+	}
+	return result
+}
+
+// Splits string into human readable part and 5 bit words without checksum.
+// No length limit, Zcash addresses exceed 90 characters.
+func decode(s string, checksumConst uint32) (string, []byte, error) {
+	if strings.ToLower(s) != s && strings.ToUpper(s) != s {
+		return "", nil, errors.New("mixed case bech32 string")
+	}
+	s = strings.ToLower(s)
+
+	pos := strings.LastIndexByte(s, '1')
+	if pos < 1 || pos+7 > len(s) {
+		return "", nil, errors.New("malformed bech32 string")
+	}
+	hrp := s[:pos]
+	for i := 0; i < len(hrp); i++ {
+		if hrp[i] < 33 || hrp[i] > 126 {
+			return "", nil, errors.New("invalid bech32 human readable part")
+		}
+	}
+	data := make([]byte, 0, len(s)-pos-1)
+	for i := pos + 1; i < len(s); i++ {
+		v := strings.IndexByte(charset, s[i])
+		if v < 0 {
+			return "", nil, fmt.Errorf("invalid bech32 character %q", s[i])
+		}
+		data = append(data, byte(v))
+	}
+	if polymod(append(hrpExpand(hrp), data...)) != checksumConst {
+		return "", nil, errors.New("bad bech32 checksum")
+	}
+	return hrp, data[:len(data)-6], nil
+}
+
+// Decodes bech32 (BIP 173) string into its human readable part and bytes
+func DecodeBech32(s string) (string, []byte, error) {
+	return decodeBytes(s, bech32Const)
+}
+
+// Decodes bech32m (BIP 350) string into its human readable part and bytes
+func DecodeBech32m(s string) (string, []byte, error) {
+	return decodeBytes(s, bech32mConst)
+}
+
+func decodeBytes(s string, checksumConst uint32) (string, []byte, error) {
+	hrp, data, err := decode(s, checksumConst)
+	if err != nil {
+		return "", nil, err
+	}
+	decoded, err := convertBits(data, 5, 8)
+	return hrp, decoded, err
+}
+
+// Regroups 5 bit words into bytes, leftover bits must be zero padding
+func convertBits(data []byte, fromBits, toBits uint) ([]byte, error) {
+	var acc uint32
+	var bits uint
+	result := make([]byte, 0, len(data)*int(fromBits)/int(toBits))
+	maxValue := uint32(1)<<toBits - 1
+	for _, v := range data {
+		acc = acc<<fromBits | uint32(v)
+		bits += fromBits
+		for bits >= toBits {
+			bits -= toBits
+			result = append(result, byte(acc>>bits&maxValue))
+		}
+	}
+	if bits >= fromBits || acc<<(toBits-bits)&maxValue != 0 {
+		return nil, errors.New("invalid bech32 padding")
+	}
+	return result, nil
+}
@@ -0,0 +1,80 @@
+package address
+
+import (
+	"errors"
+
+	"github.com/dchest/blake2b"
+)
+
+// Message length bounds of F4Jumble (ZIP 316)
+const (
+	f4JumbleMinLength = 48
+	f4JumbleMaxLength = 4194368
+)
+
+func f4JumbleLengths(m []byte) (int, int, error) {
+	if len(m) < f4JumbleMinLength || len(m) > f4JumbleMaxLength {
+		return 0, 0, errors.New("invalid F4Jumble message length")
+	}
+	left := len(m) / 2
+	if left > 64 {
+		left = 64
+	}
+	return left, len(m) - left, nil
+}
+
+// Unkeyed 4 round Feistel construction mixing the encoding of unified addresses
+func F4Jumble(m []byte) ([]byte, error) {
+	left, right, err := f4JumbleLengths(m)
+	if err != nil {
+		return nil, err
+	}
+	a, b := m[:left], m[left:]
+	x := xor(b, f4JumbleG(0, a, right))
+	y := xor(a, f4JumbleH(0, x, left))
+	d := xor(x, f4JumbleG(1, y, right))
+	c := xor(y, f4JumbleH(1, d, left))
+	return append(c, d...), nil
+}
+
+func F4JumbleInv(m []byte) ([]byte, error) {
+	left, right, err := f4JumbleLengths(m)
+	if err != nil {
+		return nil, err
+	}
+	c, d := m[:left], m[left:]
+	y := xor(c, f4JumbleH(1, d, left))
+	x := xor(d, f4JumbleG(1, y, right))
+	a := xor(y, f4JumbleH(0, x, left))
+	b := xor(x, f4JumbleG(0, a, right))
+	return append(a, b...), nil
+}
+
+func f4JumbleH(i byte, u []byte, length int) []byte {
+	return blake2bSum(length, append([]byte("UA_F4Jumble_H"), i, 0, 0), u)
+}
+
+func f4JumbleG(i byte, u []byte, length int) []byte {
+	result := make([]byte, 0, length+63)
+	for j := 0; len(result) < length; j++ {
+		result = append(result, blake2bSum(64, append([]byte("UA_F4Jumble_G"), i, byte(j), byte(j>>8)), u)...)
+	}
+	return result[:length]
+}
+
+func blake2bSum(size int, personalization, data []byte) []byte {
+	hash, err := blake2b.New(&blake2b.Config{Size: uint8(size), Person: personalization})
+	if err != nil {
+		panic(err)
+	}
+	hash.Write(data)
+	return hash.Sum(nil)
+}
+
+func xor(a, b []byte) []byte {
+	result := make([]byte, len(a))
+	for i := range a {
+		result[i] = a[i] ^ b[i]
+	}
+	return result
+}
@@ -0,0 +1,147 @@
+package address
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// Receiver typecodes of unified addresses (ZIP 316)
+const (
+	TypeP2PKH   uint64 = 0x00
+	TypeP2SH    uint64 = 0x01
+	TypeSapling uint64 = 0x02
+	TypeOrchard uint64 = 0x03
+)
+
+// Length of diversifier and pk_d of a Sapling payment address
+const saplingAddressLength = 43
+
+var receiverLengths = map[uint64]int{
+	TypeP2PKH:   20,
+	TypeP2SH:    20,
+	TypeSapling: saplingAddressLength,
+	TypeOrchard: 43,
+}
+
+// Padding of unified encoding holds the human readable part
+const unifiedPaddingLength = 16
+
+type Receiver struct {
+	Typecode uint64
+	Data     []byte
+}
+
+// Returns raw Sapling payment address
+func DecodeSapling(s, hrp string) ([]byte, error) {
+	decodedHrp, data, err := DecodeBech32(s)
+	if err != nil {
+		return nil, err
+	}
+	if decodedHrp != hrp {
+		return nil, fmt.Errorf("unexpected Sapling address prefix %s", decodedHrp)
+	}
+	if len(data) != saplingAddressLength {
+		return nil, errors.New("malformed Sapling address")
+	}
+	return data, nil
+}
+
+// Returns receivers of unified address in typecode order, unknown ones included
+func DecodeUnified(s, hrp string) ([]Receiver, error) {
+	decodedHrp, data, err := DecodeBech32m(s)
+	if err != nil {
+		return nil, err
+	}
+	if decodedHrp != hrp {
+		return nil, fmt.Errorf("unexpected unified address prefix %s", decodedHrp)
+	}
+	data, err = F4JumbleInv(data)
+	if err != nil {
+		return nil, err
+	}
+
+	padding := make([]byte, unifiedPaddingLength)
+	copy(padding, hrp)
+	if !bytes.Equal(data[len(data)-unifiedPaddingLength:], padding) {
+		return nil, errors.New("invalid unified address padding")
+	}
+	data = data[:len(data)-unifiedPaddingLength]
+
+	var receivers []Receiver
+	for len(data) > 0 {
+		typecode, n, err := readCompactSize(data)
+		if err != nil {
+			return nil, err
+		}
+		data = data[n:]
+		length, n, err := readCompactSize(data)
+		if err != nil {
+			return nil, err
+		}
+		data = data[n:]
+		if uint64(len(data)) < length {
+			return nil, errors.New("truncated unified address receiver")
+		}
+		if expected, ok := receiverLengths[typecode]; ok && uint64(expected) != length {
+			return nil, fmt.Errorf("invalid length %d of receiver %d", length, typecode)
+		}
+		if len(receivers) > 0 && receivers[len(receivers)-1].Typecode >= typecode {
+			return nil, errors.New("unified address receivers out of order")
+		}
+		receivers = append(receivers, Receiver{Typecode: typecode, Data: data[:length]})
+		data = data[length:]
+	}
+
+	return receivers, validateReceivers(receivers)
+}
+
+// At least one shielded receiver and no more than one transparent
+func validateReceivers(receivers []Receiver) error {
+	var transparent, shielded int
+	for _, receiver := range receivers {
+		switch receiver.Typecode {
+		case TypeP2PKH, TypeP2SH:
+			transparent++
+		default:
+			shielded++
+		}
+	}
+	if transparent > 1 {
+		return errors.New("unified address has both P2PKH and P2SH receivers")
+	}
+	if shielded == 0 {
+		return errors.New("unified address has no shielded receiver")
+	}
+	return nil
+}
+
+// Bitcoin style variable length integer, canonical encoding only
+func readCompactSize(data []byte) (uint64, int, error) {
+	if len(data) == 0 {
+		return 0, 0, errors.New("truncated compact size")
+	}
+	var size int
+	var min uint64
+	switch data[0] {
+	case 0xfd:
+		size, min = 2, 0xfd
+	case 0xfe:
+		size, min = 4, 0x10000
+	case 0xff:
+		size, min = 8, 0x100000000
+	default:
+		return uint64(data[0]), 1, nil
+	}
+	if len(data) < size+1 {
+		return 0, 0, errors.New("truncated compact size")
+	}
+	var value uint64
+	for i := size; i > 0; i-- {
+		value = value<<8 | uint64(data[i])
+	}
+	if value < min {
+		return 0, 0, errors.New("non-canonical compact size")
+	}
+	return value, size + 1, nil
+}
@@ -104,7 +104,7 @@ func (apiServer *ApiServer) listen() {
 	router.HandleFunc("/api/stats", apiServer.StatsIndex)
 	router.HandleFunc("/api/miners", apiServer.MinersIndex)
 	router.HandleFunc("/api/blocks", apiServer.BlocksIndex)
-	router.HandleFunc("/api/accounts/{login:t[0-9a-zA-Z]{34}}", apiServer.AccountIndex)
+	router.HandleFunc("/api/accounts/{login:[0-9a-zA-Z]+}", apiServer.AccountIndex)
 	router.NotFoundHandler = http.HandlerFunc(notFound)
 	err := http.ListenAndServe(apiServer.config.Listen, router)
 	if err != nil {
@@ -228,6 +228,11 @@ func (apiServer *ApiServer) AccountIndex(writer http.ResponseWriter, r *http.Req
 	writer.Header().Set("Cache-Control", "no-cache")
 
 	login := mux.Vars(r)["login"]
+	if !util.IsValidAddress(login) {
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+	login = util.NormalizeLogin(login)
 	apiServer.minersMu.Lock()
 	defer apiServer.minersMu.Unlock()
 
@@ -1,6 +1,7 @@
 package equihash
 
 // #cgo LDFLAGS: -L${SRCDIR}/libs -lequi
+// #include <stdlib.h>
 // #include "include/equi.h"
 import "C"
 import (
@@ -35,33 +36,71 @@ func (r *bitReader) ReadBit() (bool, error) {
 	return bit, nil
 }
 
-func (r *bitReader) Read21Bits() (uint32, error) {
+func (r *bitReader) ReadBits(count uint32) (uint32, error) {
 	var result uint32
 
-	for i := uint32(0); i < 21; i++ {
+	for i := uint32(0); i < count; i++ {
 		on, err := r.ReadBit()
 		if err != nil {
 			return 0, err
 		}
 
 		if on {
-			result |= uint32(1<<20) >> i
+			result |= uint32(1<<(count-1)) >> i
 		}
 	}
 
 	return result, nil
 }
 
+// Bits used to store a single index of the solution
+func indexBits(n, k int) int {
+	return n/(k+1) + 1
+}
+
+// Size in bytes of a packed solution, without its length prefix
+func SolutionSize(n, k int) int {
+	return (1 << uint(k)) * indexBits(n, k) / 8
+}
+
+// Personalization used by Zcash and most of its forks
+const ZcashPersonalization = "ZcashPoW"
+
+var supportedParams = map[[2]int]bool{
+	{200, 9}: true,
+	{192, 7}: true,
+	{144, 5}: true,
+	{48, 5}:  true,
+}
+
+func IsSupported(n, k int) bool {
+	return supportedParams[[2]int{n, k}]
+}
+
 // Verify POW provided by a miner.
 func Verify(n, k int, headerNonce []byte, solution []byte) (bool, error) {
+	return VerifyPersonalized(n, k, ZcashPersonalization, headerNonce, solution)
+}
+
+// Verify POW of Equihash forks using their own BLAKE2b personalization.
+func VerifyPersonalized(n, k int, personalization string, headerNonce []byte, solution []byte) (bool, error) {
 	if len(headerNonce) != 140 {
 		return false, errors.New("bad header nonce (140 bytes required)")
 	}
 
+	if !IsSupported(n, k) {
+		return false, errors.New("unsupported equihash parameters")
+	}
+
+	if len(personalization) != 8 {
+		return false, errors.New("bad personalization (8 bytes required)")
+	}
+
 	proofLen := 1 << uint(k)
+	bits := indexBits(n, k)
 
-	// We expect solution to be made up a packed bit string of 21 bits
-	if len(solution)*8 != 21*proofLen {
+	// We expect solution to be made up a packed bit string of n/(k+1)+1 bits
+	if len(solution)*8 != bits*proofLen {
 		return false, errors.New("bad solution")
 	}
 
@@ -70,17 +109,28 @@ func Verify(n, k int, headerNonce []byte, solution []byte) (bool, error) {
 	reader := newBitReader(bytes.NewBuffer(solution))
 	for i := 0; i < proofLen; i++ {
 		var err error
-		proof[i], err = reader.Read21Bits()
+		proof[i], err = reader.ReadBits(uint32(bits))
 		if err != nil {
 			return false, err
 		}
 	}
 
+	indices := (*C.uint32_t)(unsafe.Pointer(&proof[0]))
+	header := (*C.char)(unsafe.Pointer(&headerNonce[0]))
+	headerLen := (C.uint32_t)(len(headerNonce))
+	personal := C.CString(personalization)
+	defer C.free(unsafe.Pointer(personal))
+
 	var res C.int
-	if n == 200 && k == 9 {
-		res = C.verify_200_9((*C.uint32_t)(unsafe.Pointer(&proof[0])), (*C.char)(unsafe.Pointer(&headerNonce[0])), (C.uint32_t)(len(headerNonce)))
-	} else if n == 48 && k == 5 {
-		res = C.verify_48_5((*C.uint32_t)(unsafe.Pointer(&proof[0])), (*C.char)(unsafe.Pointer(&headerNonce[0])), (C.uint32_t)(len(headerNonce)))
+	switch {
+	case n == 200 && k == 9:
+		res = C.verify_200_9(indices, header, headerLen, personal)
+	case n == 192 && k == 7:
+		res = C.verify_192_7(indices, header, headerLen, personal)
+	case n == 144 && k == 5:
+		res = C.verify_144_5(indices, header, headerLen, personal)
+	case n == 48 && k == 5:
+		res = C.verify_48_5(indices, header, headerLen, personal)
 	}
 	if res != 0 {
 		return false, errors.New("pow failed")
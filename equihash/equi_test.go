@@ -44,5 +44,23 @@ func TestVerify(t *testing.T) {
 		if !ok {
 			t.Fatal(err)
 		}
+
+		ok, _ = VerifyPersonalized(v.N, v.K, "BitcoinZ", decodePanic(v.HeaderNonce), decodePanic(v.Solution))
+		if ok {
+			t.Fatal("Expected solution to fail with another personalization")
+		}
+	}
+}
+
+func TestVerifyPersonalization(t *testing.T) {
+	headerNonce := decodePanic("04000000a5b08eb465d564c690c31c08e9600110c1026f9557de51a85ebbeac35e0a00009e177df268efc2f9010938fd6cc38c634e645e4bfc369e99706564ebde801f120000000000000000000000000000000000000000000000000000000000000000b52710581a69171e62baff07000000000000000007ffba6110000000000000000000000000000005")
+	solution := make([]byte, SolutionSize(200, 9))
+
+	if _, err := VerifyPersonalized(200, 9, "ZcashPo", headerNonce, solution); err == nil {
+		t.Fatal("Expected short personalization to be rejected")
+	}
+
+	if _, err := VerifyPersonalized(210, 9, ZcashPersonalization, headerNonce, solution); err == nil {
+		t.Fatal("Expected unsupported parameters to be rejected")
 	}
 }
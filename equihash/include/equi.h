@@ -6,8 +6,11 @@
 extern "C" {
 #endif
 
-int verify_200_9(uint32_t *indices, const char *headernonce, const uint32_t headerlen);
-int verify_48_5(uint32_t *indices, const char *headernonce, const uint32_t headerlen);
+// personalization is the 8 byte BLAKE2b personal prefix, e.g. "ZcashPoW"
+int verify_200_9(uint32_t *indices, const char *headernonce, const uint32_t headerlen, const char *personalization);
+int verify_192_7(uint32_t *indices, const char *headernonce, const uint32_t headerlen, const char *personalization);
+int verify_144_5(uint32_t *indices, const char *headernonce, const uint32_t headerlen, const char *personalization);
+int verify_48_5(uint32_t *indices, const char *headernonce, const uint32_t headerlen, const char *personalization);
 
 #if defined(__cplusplus)
 }
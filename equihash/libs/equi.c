@@ -37,11 +37,12 @@ static const u32 HASHOUT = HASHESPERBLAKE*WN/8;
 
 typedef u32 proof[PROOFSIZE];
 
-static void setheader(blake2b_state *ctx, const char *headernonce) {
+static void setheader(blake2b_state *ctx, const char *headernonce, const char *personalization) {
     uint32_t le_N = htole32(WN);
     uint32_t le_K = htole32(WK);
 
-    uchar personal[] = "ZcashPoW01230123";
+    uchar personal[16];
+    memcpy(personal, personalization, 8);
     memcpy(personal+8,  &le_N, 4);
     memcpy(personal+12, &le_K, 4);
 
@@ -134,7 +135,7 @@ static bool duped(proof prf) {
 }
 
 #define FUNCTION_NAME(x, y) MAKE_FN_NAME(x, y)
-#define MAKE_FN_NAME(x, y) int verify_ ## x ## _ ## y (u32 indices[PROOFSIZE], const char *headernonce, const u32 headerlen)
+#define MAKE_FN_NAME(x, y) int verify_ ## x ## _ ## y (u32 indices[PROOFSIZE], const char *headernonce, const u32 headerlen, const char *personalization)
 
 extern "C" {
 FUNCTION_NAME(WN, WK);
@@ -152,7 +153,7 @@ FUNCTION_NAME(WN, WK)
     }
 
     blake2b_state ctx;
-    setheader(&ctx, headernonce);
+    setheader(&ctx, headernonce, personalization);
     uchar hash[WN/8];
     return verifyrec(&ctx, indices, hash, WK);
 }
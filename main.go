@@ -15,6 +15,7 @@ import (
 	"github.com/jkkgbe/open-zcash-pool/payouts"
 	"github.com/jkkgbe/open-zcash-pool/proxy"
 	"github.com/jkkgbe/open-zcash-pool/storage"
+	"github.com/jkkgbe/open-zcash-pool/util"
 )
 
 var cfg proxy.Config
@@ -34,6 +35,16 @@ func startBlockUnlocker() {
 	u.Start()
 }
 
+func startPayoutsProcessor() {
+	u := payouts.NewPayoutsProcessor(&cfg.Payouts, backend)
+	u.Start()
+}
+
+func startWalletManager() {
+	w := payouts.NewWalletManager(&cfg.Wallet, cfg.PoolAddress, backend)
+	w.Start()
+}
+
 func readConfig(cfg *proxy.Config) {
 	configFileName := "config.json"
 	if len(os.Args) > 1 {
@@ -53,10 +64,35 @@ func readConfig(cfg *proxy.Config) {
 	}
 }
 
+// Called by -blocknotify of zcashd: open-zcash-pool blocknotify <listen> %s
+func blockNotify(args []string) {
+	if len(args) != 2 {
+		log.Fatal("Usage: blocknotify <listen> <blockhash>")
+	}
+	if err := proxy.SendBlockNotify(args[0], args[1]); err != nil {
+		log.Fatal("Block notify error: ", err.Error())
+	}
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "blocknotify" {
+		blockNotify(os.Args[2:])
+		return
+	}
+
 	readConfig(&cfg)
 	rand.Seed(time.Now().UnixNano())
 
+	if err := util.SetNetwork(cfg.Network); err != nil {
+		log.Fatal("Config error: ", err.Error())
+	}
+	if len(cfg.CoinFile) > 0 {
+		if err := util.LoadCoin(cfg.CoinFile); err != nil {
+			log.Fatal("Coin file error: ", err.Error())
+		}
+	}
+	log.Printf("Running on %s (%s)", util.ActiveNetwork().Name, util.ActiveNetwork().Symbol)
+
 	if cfg.Threads > 0 {
 		runtime.GOMAXPROCS(cfg.Threads)
 		log.Printf("Running with %v threads", cfg.Threads)
@@ -79,6 +115,12 @@ func main() {
 	if cfg.BlockUnlocker.Enabled {
 		go startBlockUnlocker()
 	}
+	if cfg.Payouts.Enabled {
+		go startPayoutsProcessor()
+	}
+	if cfg.Wallet.Enabled {
+		go startWalletManager()
+	}
 	quit := make(chan bool)
 	<-quit
 }
@@ -0,0 +1,457 @@
+package payouts
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/jkkgbe/open-zcash-pool/rpc"
+	"github.com/jkkgbe/open-zcash-pool/storage"
+	"github.com/jkkgbe/open-zcash-pool/util"
+)
+
+// Number of wallet transactions fetched per listtransactions call
+const walletScanPage = 100
+
+// Scan wallet back to this many seconds before payout run was journaled
+const walletScanMargin = 3600
+
+type PayoutsConfig struct {
+	Enabled   bool           `json:"enabled"`
+	Interval  string         `json:"interval"`
+	Daemon    string         `json:"daemon"`
+	Timeout   string         `json:"timeout"`
+	Threshold int64          `json:"threshold"`
+	MinConf   int64          `json:"minConf"`
+	BgSave    bool           `json:"bgsave"`
+	Shielded  ShieldedConfig `json:"shielded"`
+}
+
+type PayoutsProcessor struct {
+	config   *PayoutsConfig
+	backend  *storage.RedisClient
+	rpc      *rpc.RPCClient
+	halt     bool
+	lastFail error
+}
+
+func NewPayoutsProcessor(cfg *PayoutsConfig, backend *storage.RedisClient) *PayoutsProcessor {
+	if cfg.Shielded.Wallet && (!cfg.Shielded.Enabled || !util.IsValidShieldedAddress(cfg.Shielded.From)) {
+		log.Fatalln("Paying from wallet manager funds needs shielded payouts from its z-address, got", cfg.Shielded.From)
+	}
+	u := &PayoutsProcessor{config: cfg, backend: backend}
+	u.rpc = rpc.NewRPCClient("PayoutsProcessor", cfg.Daemon, cfg.Timeout)
+	return u
+}
+
+func (u *PayoutsProcessor) Start() {
+	log.Println("Starting payouts")
+
+	if u.mustResolvePayout() {
+		log.Println("Running with env RESOLVE_PAYOUT=1, now trying to resolve locked payouts")
+		u.resolvePayouts()
+		log.Println("Now you have to restart payouts module with RESOLVE_PAYOUT=0 for normal run")
+		return
+	}
+
+	interval := util.MustParseDuration(u.config.Interval)
+	timer := time.NewTimer(interval)
+	log.Printf("Set payouts interval to %v", interval)
+
+	// Immediately process payouts after start, unfinished run is reconciled first
+	u.process()
+	timer.Reset(interval)
+
+	go func() {
+		for {
+			select {
+			case <-timer.C:
+				u.process()
+				timer.Reset(interval)
+			}
+		}
+	}()
+}
+
+func (u *PayoutsProcessor) process() {
+	if u.halt {
+		log.Println("Payments suspended due to last critical error:", u.lastFail)
+		return
+	}
+
+	if !u.reconcile() {
+		log.Println("Payments suspended until previous payout run is resolved")
+		return
+	}
+
+	payees, err := u.backend.GetPayees()
+	if err != nil {
+		log.Println("Error while retrieving payees from backend:", err)
+		return
+	}
+
+	amounts := make(map[string]int64)
+	shieldedAmounts := make(map[string]int64)
+
+	for _, login := range payees {
+		amount, err := u.backend.GetBalance(login)
+		if err != nil {
+			log.Printf("Failed to get balance of %v: %v", login, err)
+			continue
+		}
+		if !u.reachedThreshold(login, amount) {
+			continue
+		}
+		switch {
+		case u.config.Shielded.Wallet && util.IsValidAddress(login):
+			shieldedAmounts[login] = amount
+		case util.IsValidtAddress(login):
+			amounts[login] = amount
+		case util.IsValidShieldedAddress(login) && u.config.Shielded.Enabled:
+			shieldedAmounts[login] = amount
+		default:
+			log.Printf("Skipping payout to %v, login is not a payable address", login)
+		}
+	}
+
+	if u.config.Shielded.Wallet && len(shieldedAmounts) > 0 {
+		available, err := u.backend.GetWalletAvailable()
+		if err != nil {
+			log.Println("Failed to get shielded funds from backend:", err)
+			return
+		}
+		if limited := limitToFunds(shieldedAmounts, available); len(limited) < len(shieldedAmounts) {
+			log.Printf("Shielded funds of %v Zatoshi cover %v of %v payees", available, len(limited), len(shieldedAmounts))
+			shieldedAmounts = limited
+		}
+	}
+
+	if len(amounts) == 0 && len(shieldedAmounts) == 0 {
+		log.Println("No payees that have reached payout threshold")
+		return
+	}
+
+	if len(amounts) > 0 && !u.pay(amounts, false) {
+		return
+	}
+	if len(shieldedAmounts) > 0 && !u.pay(shieldedAmounts, true) {
+		return
+	}
+
+	if u.config.BgSave {
+		u.bgSave()
+	}
+}
+
+// Pays single run, returns false if following runs must wait
+func (u *PayoutsProcessor) pay(amounts map[string]int64, shielded bool) bool {
+	totalAmount := int64(0)
+	for _, amount := range amounts {
+		totalAmount += amount
+	}
+
+	// Lock payments for current payout
+	runId := strconv.FormatInt(util.MakeTimestamp(), 10)
+	err := u.backend.LockPayouts(runId, totalAmount)
+	if err != nil {
+		log.Printf("Failed to lock payment for run %v: %v", runId, err)
+		u.halt = true
+		u.lastFail = err
+		return false
+	}
+	log.Printf("Locked payment for run %v, %v Zatoshi to %v miners", runId, totalAmount, len(amounts))
+
+	// Journal run and debit miners' balances
+	if shielded {
+		err = u.backend.WriteShieldedPayoutRun(runId, amounts, u.config.Shielded.Wallet)
+	} else {
+		err = u.backend.WritePayoutRun(runId, amounts)
+	}
+	if err != nil {
+		log.Printf("Failed to journal payout run %v: %v", runId, err)
+		u.halt = true
+		u.lastFail = err
+		return false
+	}
+
+	var txHash string
+	if shielded {
+		txHash, err = u.sendShielded(runId, amounts)
+	} else {
+		txHash, err = u.sendTransparent(runId, amounts)
+	}
+	if failure, ok := err.(*paymentFailure); ok {
+		log.Printf("Payment of run %v failed: %v", runId, failure.reason)
+		return u.writeFailedRun(failure.reason)
+	}
+	if err != nil {
+		log.Printf("Failed to send payment for run %v: %v. Wallet will be checked for it on next payouts session",
+			runId, err)
+		return false
+	}
+
+	err = u.backend.SetPayoutRunTx(txHash)
+	if err != nil {
+		log.Printf("Failed to journal tx %v of payout run %v: %v", txHash, runId, err)
+	}
+
+	run, err := u.backend.GetPayoutRun()
+	if err == nil && run != nil {
+		err = u.backend.FinalizePayoutRun(run, txHash)
+	}
+	if err != nil {
+		log.Printf("Failed to log payment data for run %v, tx: %s: %v", runId, txHash, err)
+		u.halt = true
+		u.lastFail = err
+		return false
+	}
+
+	if shielded && u.config.Shielded.Wallet {
+		u.chargeWalletFee(txHash)
+	}
+
+	for login, amount := range amounts {
+		log.Printf("Paid %v Zatoshi to %v, TxHash: %v", amount, login, txHash)
+	}
+	log.Printf("Paid total %v Zatoshi to %v miners in tx %v", totalAmount, len(amounts), txHash)
+	return true
+}
+
+func (u *PayoutsProcessor) sendTransparent(runId string, amounts map[string]int64) (string, error) {
+	sendAmounts := make(map[string]json.Number)
+	for login, amount := range amounts {
+		sendAmounts[login] = formatAmount(amount)
+	}
+
+	// Run id goes to wallet tx comment, so we can find it if we never get the txid
+	return u.rpc.SendMany(sendAmounts, u.config.MinConf, runId)
+}
+
+func formatAmount(amount int64) json.Number {
+	return json.Number(util.FormatRatReward(new(big.Rat).SetInt64(amount)))
+}
+
+// Credits back run which certainly wasn't sent
+func (u *PayoutsProcessor) writeFailedRun(reason string) bool {
+	run, err := u.backend.GetPayoutRun()
+	if err == nil && run != nil {
+		log.Printf("Will credit back following balances of payout run %v:\n%s", run.Id, formatPendingPayments(run.Payments))
+		err = u.backend.WriteFailedPayoutRun(run, reason)
+	}
+	if err != nil {
+		log.Printf("Failed to roll back failed payout run: %v", err)
+		u.halt = true
+		u.lastFail = err
+		return false
+	}
+	return true
+}
+
+// Resolves payout run left behind by failed or interrupted session.
+// Returns false if it can't be resolved and paying again is unsafe.
+func (u *PayoutsProcessor) reconcile() bool {
+	run, err := u.backend.GetPayoutRun()
+	if err != nil {
+		log.Println("Failed to get payout run from backend:", err)
+		return false
+	}
+
+	if run == nil {
+		payments := u.backend.GetPendingPayments()
+		if len(payments) > 0 {
+			log.Printf("Found pending payments without payout run, you have to resolve it. List of failed payments:\n %v",
+				formatPendingPayments(payments))
+			return false
+		}
+
+		locked, err := u.backend.IsPayoutsLocked()
+		if err != nil {
+			log.Println("Failed to check payouts lock:", err)
+			return false
+		}
+		if locked {
+			// Nothing was debited, lock is left by interrupted session
+			log.Println("Releasing stale payouts lock")
+			err = u.backend.UnlockPayouts()
+			if err != nil {
+				log.Println("Failed to unlock payouts:", err)
+				return false
+			}
+		}
+		return true
+	}
+
+	log.Printf("Reconciling payout run %v, %v Zatoshi to %v miners", run.Id, run.Amount, len(run.Payments))
+
+	txHash := run.TxHash
+	if len(txHash) == 0 && run.Shielded {
+		var err error
+		txHash, err = u.resolveShieldedRun(run)
+		if failure, ok := err.(*paymentFailure); ok {
+			log.Printf("Payout run %v failed: %v", run.Id, failure.reason)
+			return u.writeFailedRun(failure.reason)
+		}
+		if err != nil {
+			log.Printf("Unable to resolve shielded payout run %v: %v", run.Id, err)
+			return false
+		}
+		log.Printf("Payout run %v was sent in tx %v, writing payments", run.Id, txHash)
+		err = u.backend.FinalizePayoutRun(run, txHash)
+		if err != nil {
+			log.Printf("Failed to log payment data for run %v, tx: %s: %v", run.Id, txHash, err)
+			u.halt = true
+			u.lastFail = err
+			return false
+		}
+		if run.FromWallet {
+			u.chargeWalletFee(txHash)
+		}
+		return true
+	}
+	if len(txHash) > 0 {
+		tx, err := u.rpc.GetTransaction(txHash)
+		if err != nil || tx == nil {
+			log.Printf("Unable to find tx %v of payout run %v in wallet: %v", txHash, run.Id, err)
+			return false
+		}
+	} else {
+		txHash, err = u.findRunTx(run)
+		if err != nil {
+			log.Printf("Unable to check wallet for payout run %v: %v", run.Id, err)
+			return false
+		}
+	}
+
+	if len(txHash) == 0 {
+		log.Printf("Payout run %v was never sent, will credit back following balances:\n%s",
+			run.Id, formatPendingPayments(run.Payments))
+		err = u.backend.RollbackPayoutRun(run)
+		if err != nil {
+			log.Printf("Failed to roll back payout run %v: %v", run.Id, err)
+			u.halt = true
+			u.lastFail = err
+			return false
+		}
+		return true
+	}
+
+	log.Printf("Payout run %v was sent in tx %v, writing payments", run.Id, txHash)
+	err = u.backend.FinalizePayoutRun(run, txHash)
+	if err != nil {
+		log.Printf("Failed to log payment data for run %v, tx: %s: %v", run.Id, txHash, err)
+		u.halt = true
+		u.lastFail = err
+		return false
+	}
+	return true
+}
+
+// Looks up wallet tx tagged with payout run id, empty string if it was never sent
+func (u *PayoutsProcessor) findRunTx(run *storage.PayoutRun) (string, error) {
+	for from := 0; ; from += walletScanPage {
+		txs, err := u.rpc.ListTransactions(walletScanPage, from)
+		if err != nil {
+			return "", err
+		}
+		if len(txs) == 0 {
+			return "", nil
+		}
+
+		older := true
+		for _, tx := range txs {
+			if tx.Category == "send" && tx.Comment == run.Id {
+				return tx.Txid, nil
+			}
+			if tx.Time >= run.Timestamp-walletScanMargin {
+				older = false
+			}
+		}
+		// Nothing left to scan once whole page predates the run
+		if older {
+			return "", nil
+		}
+	}
+}
+
+// Personal threshold set by miner replaces configured one
+func (u *PayoutsProcessor) reachedThreshold(login string, amount int64) bool {
+	threshold := u.config.Threshold
+	minPayout, err := u.backend.GetMinPayout(login)
+	if err != nil {
+		log.Printf("Failed to get payout threshold of %v: %v", login, err)
+	} else if minPayout > 0 {
+		threshold = minPayout
+	}
+	return threshold < amount
+}
+
+func formatPendingPayments(list []*storage.PendingPayment) string {
+	var s string
+	for _, v := range list {
+		s += fmt.Sprintf("\tAddress: %s, Amount: %v Zatoshi, %v\n", v.Address, v.Amount, time.Unix(v.Timestamp, 0))
+	}
+	return s
+}
+
+func (u *PayoutsProcessor) bgSave() {
+	result, err := u.backend.BgSave()
+	if err != nil {
+		log.Println("Failed to perform BGSAVE on backend:", err)
+		return
+	}
+	log.Println("Saving backend state to disk:", result)
+}
+
+func (u *PayoutsProcessor) resolvePayouts() {
+	run, err := u.backend.GetPayoutRun()
+	if err != nil {
+		log.Println("Failed to get payout run from backend:", err)
+		return
+	}
+	if run != nil {
+		log.Printf("Will credit back following balances of payout run %v:\n%s", run.Id, formatPendingPayments(run.Payments))
+		err = u.backend.RollbackPayoutRun(run)
+		if err != nil {
+			log.Printf("Failed to roll back payout run %v: %v", run.Id, err)
+			return
+		}
+		log.Println("Payouts unlocked")
+		return
+	}
+
+	payments := u.backend.GetPendingPayments()
+
+	if len(payments) > 0 {
+		log.Printf("Will credit back following balances:\n%s", formatPendingPayments(payments))
+
+		for _, v := range payments {
+			err := u.backend.RollbackBalance(v.Address, v.Amount)
+			if err != nil {
+				log.Printf("Failed to credit %v Zatoshi back to %s, error is: %v", v.Amount, v.Address, err)
+				return
+			}
+			log.Printf("Credited %v Zatoshi back to %s", v.Amount, v.Address)
+		}
+		err := u.backend.UnlockPayouts()
+		if err != nil {
+			log.Println("Failed to unlock payouts:", err)
+			return
+		}
+	} else {
+		log.Println("No pending payments to resolve")
+	}
+
+	if u.config.BgSave {
+		u.bgSave()
+	}
+	log.Println("Payouts unlocked")
+}
+
+func (u *PayoutsProcessor) mustResolvePayout() bool {
+	v, _ := strconv.ParseBool(os.Getenv("RESOLVE_PAYOUT"))
+	return v
+}
@@ -0,0 +1,171 @@
+package payouts
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/jkkgbe/open-zcash-pool/rpc"
+	"github.com/jkkgbe/open-zcash-pool/storage"
+	"github.com/jkkgbe/open-zcash-pool/util"
+)
+
+// Wallet address spending to z-addresses unless configured
+const defaultShieldedFrom = "ANY_TADDR"
+
+const (
+	defaultOperationPollInterval = 5 * time.Second
+	defaultOperationTimeout      = 10 * time.Minute
+)
+
+// Pays Sapling addresses by z_sendmany, privacyPolicy is required by zcashd 5
+// for spending transparent funds to z-addresses, e.g. "AllowRevealedSenders".
+// With wallet every login is paid from z-address the wallet manager shields to,
+// no more than it has released.
+type ShieldedConfig struct {
+	Enabled          bool   `json:"enabled"`
+	From             string `json:"from"`
+	PrivacyPolicy    string `json:"privacyPolicy"`
+	PollInterval     string `json:"pollInterval"`
+	OperationTimeout string `json:"operationTimeout"`
+	Wallet           bool   `json:"wallet"`
+}
+
+// Wallet refused payment, nothing was sent
+type paymentFailure struct {
+	reason string
+}
+
+func (failure *paymentFailure) Error() string {
+	return failure.reason
+}
+
+// Takes largest balances first while released funds last
+func limitToFunds(amounts map[string]int64, funds int64) map[string]int64 {
+	logins := make([]string, 0, len(amounts))
+	for login := range amounts {
+		logins = append(logins, login)
+	}
+	sort.Slice(logins, func(i, j int) bool {
+		if amounts[logins[i]] != amounts[logins[j]] {
+			return amounts[logins[i]] > amounts[logins[j]]
+		}
+		return logins[i] < logins[j]
+	})
+
+	result := make(map[string]int64)
+	for _, login := range logins {
+		if amounts[login] <= funds {
+			result[login] = amounts[login]
+			funds -= amounts[login]
+		}
+	}
+	return result
+}
+
+func (u *PayoutsProcessor) sendShielded(runId string, amounts map[string]int64) (string, error) {
+	from := u.config.Shielded.From
+	if len(from) == 0 {
+		from = defaultShieldedFrom
+	}
+	sendAmounts := make([]rpc.ZSendManyAmount, 0, len(amounts))
+	for login, amount := range amounts {
+		sendAmounts = append(sendAmounts, rpc.ZSendManyAmount{Address: login, Amount: formatAmount(amount)})
+	}
+
+	opid, err := u.rpc.ZSendMany(from, sendAmounts, u.config.MinConf, u.config.Shielded.PrivacyPolicy)
+	if rpcErr, ok := err.(*rpc.Error); ok {
+		// Node answered, so it never started the operation
+		return "", &paymentFailure{reason: rpcErr.Message}
+	}
+	if err != nil {
+		return "", err
+	}
+
+	err = u.backend.SetPayoutRunOperation(opid)
+	if err != nil {
+		log.Printf("Failed to journal operation %v of payout run %v: %v", opid, runId, err)
+	}
+	log.Printf("Started shielded payment of run %v, operation %v", runId, opid)
+	return u.waitOperation(opid)
+}
+
+// Polls operation until it's finished or timed out, then takes its result off the node
+func (u *PayoutsProcessor) waitOperation(opid string) (string, error) {
+	pollInterval := defaultOperationPollInterval
+	if len(u.config.Shielded.PollInterval) > 0 {
+		pollInterval = util.MustParseDuration(u.config.Shielded.PollInterval)
+	}
+	timeout := defaultOperationTimeout
+	if len(u.config.Shielded.OperationTimeout) > 0 {
+		timeout = util.MustParseDuration(u.config.Shielded.OperationTimeout)
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		op, err := u.rpc.ZGetOperationStatus(opid)
+		if err != nil {
+			return "", err
+		}
+		if op == nil {
+			return "", fmt.Errorf("operation %v is unknown to node", opid)
+		}
+		if op.IsFinished() {
+			break
+		}
+		if time.Now().After(deadline) {
+			return "", fmt.Errorf("operation %v is still %v after %v", opid, op.Status, timeout)
+		}
+		time.Sleep(pollInterval)
+	}
+
+	op, err := u.rpc.ZGetOperationResult(opid)
+	if err != nil {
+		return "", err
+	}
+	if op == nil {
+		return "", fmt.Errorf("operation %v is unknown to node", opid)
+	}
+	return operationTx(op)
+}
+
+func operationTx(op *rpc.ZOperation) (string, error) {
+	if op.Status == rpc.ZOperationSuccess && op.Result != nil && len(op.Result.Txid) > 0 {
+		return op.Result.Txid, nil
+	}
+	if op.Error != nil {
+		return "", &paymentFailure{reason: op.Error.Message}
+	}
+	return "", &paymentFailure{reason: "operation " + op.Status}
+}
+
+// Payout tx fee is paid from released funds too
+func (u *PayoutsProcessor) chargeWalletFee(txHash string) {
+	tx, err := u.rpc.GetTransaction(txHash)
+	if err != nil || tx == nil || len(tx.Fee) == 0 {
+		log.Printf("Unable to get fee of payout tx %v, shielded funds aren't charged for it: %v", txHash, err)
+		return
+	}
+	fee, err := util.ParseZec(tx.Fee.String())
+	if err != nil {
+		log.Printf("Malformed fee of payout tx %v: %v", txHash, err)
+		return
+	}
+	if fee < 0 {
+		fee = -fee
+	}
+	if err := u.backend.WriteWalletFee(fee); err != nil {
+		log.Printf("Failed to charge %v Zatoshi fee of payout tx %v to shielded funds: %v", fee, txHash, err)
+	}
+}
+
+// Operations live in node memory only, run is left for manual resolution once
+// node forgot it or shielded send was interrupted before returning operation id
+func (u *PayoutsProcessor) resolveShieldedRun(run *storage.PayoutRun) (string, error) {
+	if len(run.Operation) == 0 {
+		return "", errors.New("no operation id journaled, check wallet and restart with RESOLVE_PAYOUT=1 if nothing was sent")
+	}
+	return u.waitOperation(run.Operation)
+}
@@ -16,6 +16,7 @@ import (
 type UnlockerConfig struct {
 	Enabled        bool    `json:"enabled"`
 	PoolFee        float64 `json:"poolFee"`
+	SoloFee        float64 `json:"soloFee"`
 	PoolFeeAddress string  `json:"poolFeeAddress"`
 	Donate         bool    `json:"donate"`
 	Depth          int64   `json:"depth"`
@@ -344,8 +345,42 @@ func (u *BlockUnlocker) unlockAndCreditMiners() {
 }
 
 func (u *BlockUnlocker) calculateRewards(block *storage.BlockData) (*big.Rat, *big.Rat, *big.Rat, map[string]int64, error) {
+	// Miners were paid for PPS shares already, the reward refills pool reserve
+	if block.Scheme == storage.SchemePPS {
+		revenue := new(big.Rat).SetInt(block.Reward)
+		return revenue, new(big.Rat), revenue, make(map[string]int64), nil
+	}
+
+	// Outputs were paid by the coinbase, credits and fee stayed on pool address
+	if block.Scheme == storage.SchemeCoinbase {
+		payouts, err := u.backend.GetCoinbasePayouts(block.Hash)
+		if err != nil {
+			return nil, nil, nil, nil, err
+		}
+		// Split included tx fees even if keepTxFees leaves them out of block reward
+		revenue := new(big.Rat).SetInt(block.Reward)
+		if payouts.Reward > 0 {
+			revenue.SetInt64(payouts.Reward)
+		}
+		minersProfit := new(big.Rat)
+		rewards := make(map[string]int64)
+		for _, amount := range payouts.Outputs {
+			minersProfit.Add(minersProfit, new(big.Rat).SetInt64(amount))
+		}
+		for login, amount := range payouts.Credits {
+			minersProfit.Add(minersProfit, new(big.Rat).SetInt64(amount))
+			rewards[login] += amount
+		}
+		poolProfit := new(big.Rat).Sub(revenue, minersProfit)
+		return revenue, minersProfit, poolProfit, rewards, nil
+	}
+
+	fee := u.config.PoolFee
+	if block.Scheme == storage.SchemeSolo {
+		fee = u.config.SoloFee
+	}
 	revenue := new(big.Rat).SetInt(block.Reward)
-	minersProfit, poolProfit := chargeFee(revenue, u.config.PoolFee)
+	minersProfit, poolProfit := chargeFee(revenue, fee)
 
 	shares, err := u.backend.GetRoundShares(block.RoundHeight, block.Nonce)
 	if err != nil {
@@ -0,0 +1,221 @@
+package payouts
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jkkgbe/open-zcash-pool/rpc"
+	"github.com/jkkgbe/open-zcash-pool/storage"
+	"github.com/jkkgbe/open-zcash-pool/util"
+)
+
+// Coinbase outputs can be spent after this many confirmations
+const coinbaseMaturity = 100
+
+const defaultShieldingLimit = 50
+
+type WalletConfig struct {
+	Enabled   bool   `json:"enabled"`
+	Interval  string `json:"interval"`
+	Daemon    string `json:"daemon"`
+	Timeout   string `json:"timeout"`
+	ShieldTo  string `json:"shieldTo"`
+	Threshold int64  `json:"threshold"`
+	Limit     int    `json:"limit"`
+	MinConf   int64  `json:"minConf"`
+}
+
+// Shields matured coinbase of pool address to a z-address and releases it to
+// payouts once shielding tx is confirmed
+type WalletManager struct {
+	config      *WalletConfig
+	poolAddress string
+	backend     *storage.RedisClient
+	rpc         *rpc.RPCClient
+	halt        bool
+	lastFail    error
+}
+
+func NewWalletManager(cfg *WalletConfig, poolAddress string, backend *storage.RedisClient) *WalletManager {
+	if !util.IsValidShieldedAddress(cfg.ShieldTo) {
+		log.Fatalln("Invalid shieldTo shielded address", cfg.ShieldTo)
+	}
+	if cfg.Limit <= 0 {
+		cfg.Limit = defaultShieldingLimit
+	}
+	if cfg.MinConf <= 0 {
+		cfg.MinConf = 1
+	}
+	w := &WalletManager{config: cfg, poolAddress: poolAddress, backend: backend}
+	w.rpc = rpc.NewRPCClient("WalletManager", cfg.Daemon, cfg.Timeout)
+	return w
+}
+
+func (w *WalletManager) Start() {
+	log.Println("Starting wallet manager")
+	interval := util.MustParseDuration(w.config.Interval)
+	timer := time.NewTimer(interval)
+	log.Printf("Set wallet manager interval to %v", interval)
+
+	w.process()
+	timer.Reset(interval)
+
+	go func() {
+		for {
+			select {
+			case <-timer.C:
+				w.process()
+				timer.Reset(interval)
+			}
+		}
+	}()
+}
+
+func (w *WalletManager) process() {
+	if w.halt {
+		log.Println("Wallet manager suspended due to last critical error:", w.lastFail)
+		return
+	}
+
+	shielding, err := w.checkShielding()
+	if err != nil {
+		log.Println("Failed to check coinbase shielding:", err)
+		return
+	}
+	w.releaseShielded()
+	if !shielding {
+		w.shieldCoinbase()
+	}
+}
+
+// Resolves operation in progress, returns true while it's still running
+func (w *WalletManager) checkShielding() (bool, error) {
+	op, err := w.backend.GetShieldingOperation()
+	if err != nil || op == nil {
+		return false, err
+	}
+
+	status, err := w.rpc.ZGetOperationStatus(op.Opid)
+	if err != nil {
+		return true, err
+	}
+	if status == nil {
+		// Node was restarted, whatever it sent shows up in the z-address balance
+		log.Printf("Shielding operation %v is unknown to node, check wallet of %v", op.Opid, w.config.ShieldTo)
+		return false, w.writeShieldingResult(op, "", "operation unknown to node")
+	}
+	if !status.IsFinished() {
+		log.Printf("Shielding %v Zatoshi, operation %v is %v", op.Amount, op.Opid, status.Status)
+		return true, nil
+	}
+
+	result, err := w.rpc.ZGetOperationResult(op.Opid)
+	if err != nil {
+		return true, err
+	}
+	if result == nil {
+		result = status
+	}
+	txHash, err := operationTx(result)
+	if err != nil {
+		log.Printf("Shielding operation %v failed: %v", op.Opid, err)
+		return false, w.writeShieldingResult(op, "", err.Error())
+	}
+	log.Printf("Shielded %v Zatoshi in tx %v", op.Amount, txHash)
+	return false, w.writeShieldingResult(op, txHash, "")
+}
+
+func (w *WalletManager) writeShieldingResult(op *storage.ShieldingOperation, txHash, reason string) error {
+	err := w.backend.WriteShieldingResult(op, txHash, reason)
+	if err != nil {
+		w.halt = true
+		w.lastFail = err
+	}
+	return err
+}
+
+func (w *WalletManager) releaseShielded() {
+	pending, err := w.backend.GetPendingShieldedTxs()
+	if err != nil {
+		log.Println("Failed to get pending shielding txs from backend:", err)
+		return
+	}
+
+	for _, shieldedTx := range pending {
+		tx, err := w.rpc.GetTransaction(shieldedTx.TxHash)
+		if err != nil || tx == nil {
+			log.Printf("Unable to find shielding tx %v in wallet: %v", shieldedTx.TxHash, err)
+			continue
+		}
+		if tx.Confirmations < w.config.MinConf {
+			continue
+		}
+		// Wallet reports fee of sent tx as negative amount
+		var fee int64
+		if len(tx.Fee) > 0 {
+			if fee, err = util.ParseZec(tx.Fee.String()); err != nil {
+				log.Printf("Malformed fee of shielding tx %v: %v", shieldedTx.TxHash, err)
+				continue
+			}
+		}
+		if fee < 0 {
+			fee = -fee
+		}
+		err = w.backend.ReleaseShieldedTx(shieldedTx, fee)
+		if err != nil {
+			log.Printf("Failed to release %v Zatoshi of shielding tx %v: %v", shieldedTx.Amount-fee, shieldedTx.TxHash, err)
+			w.halt = true
+			w.lastFail = err
+			return
+		}
+		log.Printf("Released %v Zatoshi of shielding tx %v to payouts", shieldedTx.Amount-fee, shieldedTx.TxHash)
+	}
+}
+
+func (w *WalletManager) shieldCoinbase() {
+	unspent, err := w.rpc.ListUnspent(coinbaseMaturity, []string{w.poolAddress})
+	if err != nil {
+		log.Println("Failed to list unspent outputs of pool address:", err)
+		return
+	}
+
+	matured := int64(0)
+	for _, output := range unspent {
+		if !output.Generated {
+			continue
+		}
+		amount, err := util.ParseZec(output.Amount.String())
+		if err != nil {
+			log.Printf("Skipping coinbase output %v:%v: %v", output.Txid, output.Vout, err)
+			continue
+		}
+		matured += amount
+	}
+	if matured < w.config.Threshold || matured == 0 {
+		return
+	}
+
+	reply, err := w.rpc.ZShieldCoinbase(w.poolAddress, w.config.ShieldTo, w.config.Limit)
+	if err != nil {
+		log.Printf("Failed to shield %v Zatoshi of matured coinbase: %v", matured, err)
+		return
+	}
+	amount, err := util.ParseZec(reply.ShieldingValue.String())
+	if err != nil {
+		// Journaling unknown amount would never release it, operator has to check the operation
+		log.Printf("Malformed shielding value of operation %v: %v", reply.Opid, err)
+		w.halt = true
+		w.lastFail = fmt.Errorf("malformed shielding value of operation %v: %v", reply.Opid, err)
+		return
+	}
+	err = w.backend.WriteShieldingOperation(reply.Opid, amount)
+	if err != nil {
+		log.Printf("Failed to journal shielding operation %v: %v", reply.Opid, err)
+		w.halt = true
+		w.lastFail = err
+		return
+	}
+	log.Printf("Shielding %v Zatoshi in %v coinbase outputs to %v, operation %v, %v outputs remaining",
+		amount, reply.ShieldingUTXOs, w.config.ShieldTo, reply.Opid, reply.RemainingUTXOs)
+}
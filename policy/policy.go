@@ -0,0 +1,377 @@
+package policy
+
+import (
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jkkgbe/open-zcash-pool/storage"
+	"github.com/jkkgbe/open-zcash-pool/util"
+)
+
+// Used when policy section leaves intervals out
+const (
+	defaultGrace           = 5 * time.Minute
+	defaultResetInterval   = time.Hour
+	defaultRefreshInterval = time.Minute
+)
+
+type Config struct {
+	Workers         int     `json:"workers"`
+	Banning         Banning `json:"banning"`
+	Limits          Limits  `json:"limits"`
+	ResetInterval   string  `json:"resetInterval"`
+	RefreshInterval string  `json:"refreshInterval"`
+}
+
+type Limits struct {
+	Enabled   bool   `json:"enabled"`
+	Limit     int32  `json:"limit"`
+	Grace     string `json:"grace"`
+	LimitJump int32  `json:"limitJump"`
+}
+
+type Banning struct {
+	Enabled        bool    `json:"enabled"`
+	IPSet          string  `json:"ipset"`
+	Timeout        int64   `json:"timeout"`
+	InvalidPercent float32 `json:"invalidPercent"`
+	CheckThreshold int32   `json:"checkThreshold"`
+	MalformedLimit int32   `json:"malformedLimit"`
+}
+
+type Stats struct {
+	sync.Mutex
+	// Accessed atomically, keep 64-bit fields first for alignment
+	LastBeat      int64
+	BannedAt      int64
+	ValidShares   int32
+	InvalidShares int32
+	Malformed     int32
+	ConnLimit     int32
+	Banned        int32
+}
+
+type PolicyServer struct {
+	sync.RWMutex
+	statsMu    sync.Mutex
+	config     *Config
+	stats      map[string]*Stats
+	banChannel chan string
+	startedAt  int64
+	grace      int64
+	timeout    int64
+	blacklist  []string
+	whitelist  []string
+	storage    *storage.RedisClient
+}
+
+func Start(cfg *Config, storage *storage.RedisClient) *PolicyServer {
+	policyServer := &PolicyServer{config: cfg, startedAt: util.MakeTimestamp()}
+	grace := parseDuration(cfg.Limits.Grace, defaultGrace)
+	policyServer.grace = int64(grace / time.Millisecond)
+	policyServer.banChannel = make(chan string, 64)
+	policyServer.stats = make(map[string]*Stats)
+	policyServer.storage = storage
+	policyServer.refreshState()
+
+	resetInterval := parseDuration(cfg.ResetInterval, defaultResetInterval)
+	policyServer.timeout = int64(resetInterval / time.Millisecond)
+	resetTimer := time.NewTimer(resetInterval)
+	log.Printf("Set policy stats reset every %v", resetInterval)
+
+	refreshInterval := parseDuration(cfg.RefreshInterval, defaultRefreshInterval)
+	refreshTimer := time.NewTimer(refreshInterval)
+	log.Printf("Set policy state refresh every %v", refreshInterval)
+
+	go func() {
+		for {
+			select {
+			case <-resetTimer.C:
+				policyServer.resetStats()
+				resetTimer.Reset(resetInterval)
+			case <-refreshTimer.C:
+				policyServer.refreshState()
+				refreshTimer.Reset(refreshInterval)
+			}
+		}
+	}()
+
+	for i := 0; i < cfg.Workers; i++ {
+		policyServer.startPolicyWorker()
+	}
+	log.Printf("Running with %v policy workers", cfg.Workers)
+	return policyServer
+}
+
+func parseDuration(value string, fallback time.Duration) time.Duration {
+	if len(value) == 0 {
+		return fallback
+	}
+	return util.MustParseDuration(value)
+}
+
+func (policyServer *PolicyServer) startPolicyWorker() {
+	go func() {
+		for {
+			select {
+			case ip := <-policyServer.banChannel:
+				policyServer.doBan(ip)
+			}
+		}
+	}()
+}
+
+func (policyServer *PolicyServer) resetStats() {
+	now := util.MakeTimestamp()
+	banningTimeout := policyServer.config.Banning.Timeout * 1000
+	total := 0
+	policyServer.statsMu.Lock()
+	defer policyServer.statsMu.Unlock()
+
+	for key, stats := range policyServer.stats {
+		lastBeat := atomic.LoadInt64(&stats.LastBeat)
+		bannedAt := atomic.LoadInt64(&stats.BannedAt)
+
+		if now-bannedAt >= banningTimeout {
+			atomic.StoreInt64(&stats.BannedAt, 0)
+			if atomic.CompareAndSwapInt32(&stats.Banned, 1, 0) {
+				log.Printf("Ban dropped for %v", key)
+				delete(policyServer.stats, key)
+				total++
+				continue
+			}
+		}
+		if now-lastBeat >= policyServer.timeout {
+			delete(policyServer.stats, key)
+			total++
+		}
+	}
+	log.Printf("Flushed stats for %v IP addresses", total)
+}
+
+func (policyServer *PolicyServer) refreshState() {
+	if policyServer.storage == nil {
+		return
+	}
+	blacklist, err := policyServer.storage.GetBlacklist()
+	if err != nil {
+		log.Printf("Failed to get blacklist from backend: %v", err)
+		return
+	}
+	whitelist, err := policyServer.storage.GetWhitelist()
+	if err != nil {
+		log.Printf("Failed to get whitelist from backend: %v", err)
+		return
+	}
+
+	policyServer.Lock()
+	policyServer.blacklist = blacklist
+	policyServer.whitelist = whitelist
+	policyServer.Unlock()
+	log.Println("Policy state refresh complete")
+}
+
+func (policyServer *PolicyServer) NewStats() *Stats {
+	stats := &Stats{
+		ConnLimit: policyServer.config.Limits.Limit,
+	}
+	stats.heartbeat()
+	return stats
+}
+
+func (policyServer *PolicyServer) Get(ip string) *Stats {
+	policyServer.statsMu.Lock()
+	defer policyServer.statsMu.Unlock()
+
+	if stats, ok := policyServer.stats[ip]; !ok {
+		stats = policyServer.NewStats()
+		policyServer.stats[ip] = stats
+		return stats
+	} else {
+		stats.heartbeat()
+		return stats
+	}
+}
+
+func (policyServer *PolicyServer) BanClient(ip string) {
+	stats := policyServer.Get(ip)
+	policyServer.forceBan(stats, ip)
+}
+
+// Banned in memory, by backend ban surviving restarts or blacklisted
+func (policyServer *PolicyServer) IsBanned(ip string) bool {
+	if policyServer.InWhiteList(ip) {
+		return false
+	}
+	if policyServer.InBlackList(ip) {
+		return true
+	}
+	stats := policyServer.Get(ip)
+	if atomic.LoadInt32(&stats.Banned) > 0 {
+		return true
+	}
+	if policyServer.storage == nil {
+		return false
+	}
+	banned, err := policyServer.storage.IsBanned(ip)
+	if err != nil {
+		log.Printf("Failed to check ban of %v in backend: %v", ip, err)
+		return false
+	}
+	return banned
+}
+
+// Each connection uses up one slot of the IP limit until it's closed, valid shares
+// add slots. Limit isn't enforced during grace period, slots are taken anyway.
+func (policyServer *PolicyServer) ApplyLimitPolicy(ip string) bool {
+	if !policyServer.config.Limits.Enabled || policyServer.InWhiteList(ip) {
+		return true
+	}
+	stats := policyServer.Get(ip)
+	if stats.decrLimit() >= 0 {
+		return true
+	}
+	now := util.MakeTimestamp()
+	if now-policyServer.startedAt > policyServer.grace {
+		stats.incrLimit(1)
+		return false
+	}
+	return true
+}
+
+// Gives slot of closed connection back
+func (policyServer *PolicyServer) ReleaseLimit(ip string) {
+	if !policyServer.config.Limits.Enabled || policyServer.InWhiteList(ip) {
+		return
+	}
+	policyServer.Get(ip).incrLimit(1)
+}
+
+func (policyServer *PolicyServer) ApplyLoginPolicy(login, ip string) bool {
+	if policyServer.InBlackList(login) {
+		stats := policyServer.Get(ip)
+		policyServer.forceBan(stats, ip)
+		return false
+	}
+	return true
+}
+
+func (policyServer *PolicyServer) ApplyMalformedPolicy(ip string) bool {
+	stats := policyServer.Get(ip)
+	n := stats.incrMalformed()
+	if n >= policyServer.config.Banning.MalformedLimit {
+		policyServer.forceBan(stats, ip)
+		return false
+	}
+	return true
+}
+
+func (policyServer *PolicyServer) ApplySharePolicy(ip string, validShare bool) bool {
+	stats := policyServer.Get(ip)
+	stats.Lock()
+
+	if validShare {
+		stats.ValidShares++
+		if policyServer.config.Limits.Enabled {
+			stats.incrLimit(policyServer.config.Limits.LimitJump)
+		}
+	} else {
+		stats.InvalidShares++
+	}
+
+	totalShares := stats.ValidShares + stats.InvalidShares
+	if totalShares < policyServer.config.Banning.CheckThreshold {
+		stats.Unlock()
+		return true
+	}
+	invalidShares := float32(stats.InvalidShares)
+	stats.resetShares()
+	stats.Unlock()
+
+	if invalidShares/float32(totalShares) >= policyServer.config.Banning.InvalidPercent/100.0 {
+		policyServer.forceBan(stats, ip)
+		return false
+	}
+	return true
+}
+
+func (stats *Stats) resetShares() {
+	stats.ValidShares = 0
+	stats.InvalidShares = 0
+}
+
+func (policyServer *PolicyServer) forceBan(stats *Stats, ip string) {
+	if !policyServer.config.Banning.Enabled || policyServer.InWhiteList(ip) {
+		return
+	}
+	atomic.StoreInt64(&stats.BannedAt, util.MakeTimestamp())
+
+	if atomic.CompareAndSwapInt32(&stats.Banned, 0, 1) {
+		policyServer.writeBan(ip)
+		if len(policyServer.config.Banning.IPSet) > 0 {
+			policyServer.banChannel <- ip
+		} else {
+			log.Println("Banned peer", ip)
+		}
+	}
+}
+
+func (policyServer *PolicyServer) writeBan(ip string) {
+	timeout := time.Duration(policyServer.config.Banning.Timeout) * time.Second
+	if policyServer.storage == nil || timeout <= 0 {
+		return
+	}
+	if err := policyServer.storage.WriteBan(ip, timeout); err != nil {
+		log.Printf("Failed to write ban of %v to backend: %v", ip, err)
+	}
+}
+
+func (stats *Stats) incrLimit(n int32) {
+	atomic.AddInt32(&stats.ConnLimit, n)
+}
+
+func (stats *Stats) incrMalformed() int32 {
+	return atomic.AddInt32(&stats.Malformed, 1)
+}
+
+func (stats *Stats) decrLimit() int32 {
+	return atomic.AddInt32(&stats.ConnLimit, -1)
+}
+
+// Blacklist holds both logins and IP addresses
+func (policyServer *PolicyServer) InBlackList(entry string) bool {
+	policyServer.RLock()
+	defer policyServer.RUnlock()
+	return util.StringInSlice(entry, policyServer.blacklist)
+}
+
+func (policyServer *PolicyServer) InWhiteList(ip string) bool {
+	policyServer.RLock()
+	defer policyServer.RUnlock()
+	return util.StringInSlice(ip, policyServer.whitelist)
+}
+
+func (policyServer *PolicyServer) doBan(ip string) {
+	set, timeout := policyServer.config.Banning.IPSet, policyServer.config.Banning.Timeout
+	cmd := fmt.Sprintf("sudo ipset add %s %s timeout %v -!", set, ip, timeout)
+	args := strings.Fields(cmd)
+	head := args[0]
+	args = args[1:]
+
+	log.Printf("Banned %v with timeout %v on ipset %s", ip, timeout, set)
+
+	_, err := exec.Command(head, args...).Output()
+	if err != nil {
+		log.Printf("CMD Error: %s", err)
+	}
+}
+
+func (stats *Stats) heartbeat() {
+	now := util.MakeTimestamp()
+	atomic.StoreInt64(&stats.LastBeat, now)
+}
@@ -0,0 +1,73 @@
+package policy
+
+import "testing"
+
+func newTestServer() *PolicyServer {
+	cfg := &Config{
+		Banning: Banning{Enabled: true, InvalidPercent: 30, CheckThreshold: 10, MalformedLimit: 3},
+		Limits:  Limits{Enabled: true, Limit: 2, LimitJump: 2},
+	}
+	return &PolicyServer{config: cfg, stats: make(map[string]*Stats), whitelist: []string{"10.0.0.1"}}
+}
+
+func TestApplySharePolicy(t *testing.T) {
+	policyServer := newTestServer()
+
+	for i := 0; i < 8; i++ {
+		policyServer.ApplySharePolicy("1.1.1.1", true)
+	}
+	policyServer.ApplySharePolicy("1.1.1.1", false)
+	if !policyServer.ApplySharePolicy("1.1.1.1", false) {
+		t.Error("20% of invalid shares must not ban")
+	}
+
+	for i := 0; i < 6; i++ {
+		policyServer.ApplySharePolicy("2.2.2.2", true)
+	}
+	for i := 0; i < 3; i++ {
+		policyServer.ApplySharePolicy("2.2.2.2", false)
+	}
+	if policyServer.ApplySharePolicy("2.2.2.2", false) {
+		t.Error("40% of invalid shares must ban")
+	}
+	if !policyServer.IsBanned("2.2.2.2") {
+		t.Error("peer must be banned")
+	}
+}
+
+func TestApplyMalformedPolicy(t *testing.T) {
+	policyServer := newTestServer()
+
+	for _, ip := range []string{"1.1.1.1", "10.0.0.1"} {
+		policyServer.ApplyMalformedPolicy(ip)
+		policyServer.ApplyMalformedPolicy(ip)
+		policyServer.ApplyMalformedPolicy(ip)
+	}
+	if !policyServer.IsBanned("1.1.1.1") {
+		t.Error("peer must be banned after malformed limit")
+	}
+	if policyServer.IsBanned("10.0.0.1") {
+		t.Error("whitelisted peer must never be banned")
+	}
+}
+
+func TestApplyLimitPolicy(t *testing.T) {
+	policyServer := newTestServer()
+
+	for i := 0; i < 2; i++ {
+		if !policyServer.ApplyLimitPolicy("1.1.1.1") {
+			t.Errorf("connection %d within limit must be allowed", i+1)
+		}
+	}
+	if policyServer.ApplyLimitPolicy("1.1.1.1") {
+		t.Error("connection over limit must be refused")
+	}
+	policyServer.ReleaseLimit("1.1.1.1")
+	if !policyServer.ApplyLimitPolicy("1.1.1.1") {
+		t.Error("closed connection must give its slot back")
+	}
+	policyServer.ApplySharePolicy("1.1.1.1", true)
+	if !policyServer.ApplyLimitPolicy("1.1.1.1") {
+		t.Error("valid share must allow another connection")
+	}
+}
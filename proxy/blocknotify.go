@@ -0,0 +1,95 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const blockNotifyPath = "/blocknotify/"
+
+var blockHashPattern = regexp.MustCompile("^[0-9a-f]{64}$")
+
+type BlockNotify struct {
+	Enabled bool   `json:"enabled"`
+	Listen  string `json:"listen"`
+}
+
+// Address is unix:/path/to/socket or host:port of a local HTTP listener
+func blockNotifyListener(addr string) (net.Listener, error) {
+	if strings.HasPrefix(addr, "unix:") {
+		path := strings.TrimPrefix(addr, "unix:")
+		// Socket left over by a previous run
+		os.Remove(path)
+		return net.Listen("unix", path)
+	}
+	return net.Listen("tcp", addr)
+}
+
+// Lets -blocknotify of zcashd trigger work refresh
+func (proxyServer *ProxyServer) listenBlockNotify() {
+	addr := proxyServer.config.Proxy.BlockNotify.Listen
+	listener, err := blockNotifyListener(addr)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	log.Printf("Block notify listening on %s", addr)
+
+	handler := blockNotifyHandler(func(blockHash string) {
+		log.Printf("New block %s announced by blocknotify", blockHash)
+		proxyServer.fetchWork()
+	})
+	log.Fatal(http.Serve(listener, handler))
+}
+
+func blockNotifyHandler(notify func(blockHash string)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || !strings.HasPrefix(r.URL.Path, blockNotifyPath) {
+			http.NotFound(w, r)
+			return
+		}
+		blockHash := strings.ToLower(strings.TrimPrefix(r.URL.Path, blockNotifyPath))
+		if !blockHashPattern.MatchString(blockHash) {
+			http.Error(w, "Malformed block hash", http.StatusBadRequest)
+			return
+		}
+		notify(blockHash)
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// Client side of the blocknotify subcommand
+func SendBlockNotify(addr, blockHash string) error {
+	client := &http.Client{Timeout: 10 * time.Second}
+	url := "http://" + addr + blockNotifyPath + blockHash
+
+	if strings.HasPrefix(addr, "unix:") {
+		path := strings.TrimPrefix(addr, "unix:")
+		client.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", path)
+			},
+		}
+		url = "http://unix" + blockNotifyPath + blockHash
+	}
+
+	resp, err := client.Post(url, "text/plain", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
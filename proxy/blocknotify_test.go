@@ -0,0 +1,41 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBlockNotify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blocknotify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	addr := "unix:" + filepath.Join(dir, "pool.sock")
+	listener, err := blockNotifyListener(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	notified := make(chan string, 1)
+	go http.Serve(listener, blockNotifyHandler(func(blockHash string) {
+		notified <- blockHash
+	}))
+
+	blockHash := "0000000001c52a4ea79f1d6cc1b7c2b2b7b0c6f7d9b3b9c6e5b2a2b6c3d4e5f6"
+	if err := SendBlockNotify(addr, blockHash); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-notified; got != blockHash {
+		t.Errorf("got block %s, want %s", got, blockHash)
+	}
+
+	if err := SendBlockNotify(addr, "zz"); err == nil {
+		t.Error("malformed block hash must be refused")
+	}
+}
@@ -1,19 +1,41 @@
 package proxy
 
 import (
+	"errors"
 	"log"
 	"math/big"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/jkkgbe/open-zcash-pool/merkleTree"
+	"github.com/jkkgbe/open-zcash-pool/rpc"
+	"github.com/jkkgbe/open-zcash-pool/storage"
 	"github.com/jkkgbe/open-zcash-pool/transaction"
 	"github.com/jkkgbe/open-zcash-pool/util"
 )
 
+const (
+	CoinbaseModeCustom = "custom"
+	CoinbaseModeDaemon = "daemon"
+)
+
+const (
+	RewardSchemeProp  = "prop"
+	RewardSchemePPLNS = "pplns"
+	RewardSchemePPS   = "pps"
+	RewardSchemeFPPS  = "fpps"
+)
+
+// N of PPLNS as a multiple of network difficulty
+const defaultPPLNSWindow = 2.0
+
 type Transaction struct {
-	Data string `json:"data"`
-	Hash string `json:"hash"`
-	Fee  int64  `json:"fee"`
+	Data       string `json:"data"`
+	Hash       string `json:"hash"`
+	AuthDigest string `json:"authdigest"`
+	Fee        int64  `json:"fee"`
 }
 
 type CoinbaseTxn struct {
@@ -22,6 +44,11 @@ type CoinbaseTxn struct {
 	FoundersReward int64  `json:"foundersreward"`
 }
 
+type DefaultRoots struct {
+	ChainHistoryRoot     string `json:"chainhistoryroot"`
+	BlockCommitmentsHash string `json:"blockcommitmentshash"`
+}
+
 type BlockTemplate struct {
 	sync.RWMutex
 	Version              uint32        `json:"version"`
@@ -29,6 +56,7 @@ type BlockTemplate struct {
 	FinalSaplingRootHash string        `json:"finalsaplingroothash"`
 	Transactions         []Transaction `json:"transactions"`
 	CoinbaseTxn          CoinbaseTxn   `json:"coinbasetxn"`
+	DefaultRoots         DefaultRoots  `json:"defaultroots"`
 	LongpollId           string        `json:"longpollid"`
 	Target               string        `json:"target"`
 	MinTime              int           `json:"mintime"`
@@ -40,6 +68,7 @@ type BlockTemplate struct {
 	Height               int64         `json:"height"`
 }
 
+// FinalSaplingRootHash holds the block commitments hash since NU5
 type Work struct {
 	JobId                string
 	Version              string
@@ -55,11 +84,12 @@ type Work struct {
 	Template             *BlockTemplate
 	GeneratedCoinbase    []byte
 	FeeReward            int64
+	Payouts              *storage.CoinbasePayouts
+	CreatedAt            time.Time
 }
 
 func (proxyServer *ProxyServer) fetchWork() {
 	rpc := proxyServer.rpc()
-	currentWork := proxyServer.currentWork()
 
 	var blockTemplate BlockTemplate
 	err := rpc.GetBlockTemplate(&blockTemplate)
@@ -68,25 +98,63 @@ func (proxyServer *ProxyServer) fetchWork() {
 		return
 	}
 
-	// No need to update, we already have a fresh job
-	if currentWork != nil && util.ReverseHex(currentWork.PrevHashReversed) == blockTemplate.PrevBlockHash {
-		return
-	}
+	proxyServer.updateWork(rpc, &blockTemplate)
+}
+
+// Turns template into new work unless the current one is still fresh
+func (proxyServer *ProxyServer) updateWork(rpc *rpc.RPCClient, blockTemplate *BlockTemplate) {
+	proxyServer.workMu.Lock()
+	defer proxyServer.workMu.Unlock()
+
+	currentWork := proxyServer.currentWork()
 
 	var feeReward int64 = 0
 	for _, transaction := range blockTemplate.Transactions {
 		feeReward += transaction.Fee
 	}
 
-	coinbaseTxn, coinbaseHash := transaction.BuildCoinbaseTxn(
-		blockTemplate.Height,
-		proxyServer.config.PoolAddress,
-		blockTemplate.CoinbaseTxn.FoundersReward,
-		feeReward,
-	)
+	cleanJobs := currentWork == nil || util.ReverseHex(currentWork.PrevHashReversed) != blockTemplate.PrevBlockHash
+	// No need to update, we already have a fresh job
+	if !cleanJobs && !proxyServer.isTemplateUpdated(currentWork, blockTemplate, feeReward) {
+		return
+	}
+
+	target, _ := new(big.Int).SetString(blockTemplate.Target, 16)
+	difficulty := new(big.Int).Div(util.ActiveNetwork().PowLimit, target)
+	if proxyServer.config.Proxy.RewardScheme == RewardSchemePPLNS {
+		minShareDiff := atomic.LoadInt64(&proxyServer.minShareDiff)
+		proxyServer.backend.SetPPLNSLimit(pplnsLimit(proxyServer.config.Proxy.PPLNSWindow, difficulty, minShareDiff))
+	}
+
+	var coinbase *transaction.Coinbase
+	var finalSaplingRootHash string
+	var payouts *storage.CoinbasePayouts
+	var err error
+	if proxyServer.config.Proxy.CoinbaseMode == CoinbaseModeDaemon {
+		coinbase, finalSaplingRootHash, err = daemonCoinbase(blockTemplate)
+	} else {
+		if proxyServer.config.Proxy.CoinbasePayouts.Enabled {
+			reward := util.GetConstReward(blockTemplate.Height).Int64() + feeReward
+			payouts = proxyServer.coinbasePayouts(blockTemplate.Height, reward, difficulty)
+		}
+		var params *coinbaseParams
+		params, err = proxyServer.coinbaseParams(rpc, blockTemplate)
+		if err == nil {
+			coinbase, finalSaplingRootHash, err = buildCoinbase(params, blockTemplate, proxyServer.config.PoolAddress, feeReward, payoutOutputs(payouts))
+		}
+	}
+	if err != nil {
+		log.Printf("Error while preparing coinbase transaction on %s at height %d: %s", rpc.Name, blockTemplate.Height, err)
+		return
+	}
+	if payouts != nil {
+		txHash := make([]byte, len(coinbase.Hash))
+		copy(txHash, coinbase.Hash[:])
+		payouts.TxHash = util.BytesToHex(util.ReverseBuffer(txHash))
+	}
 
 	txHashes := make([][32]byte, len(blockTemplate.Transactions)+1)
-	copy(txHashes[0][:], coinbaseHash[:])
+	copy(txHashes[0][:], coinbase.Hash[:])
 
 	for i, transaction := range blockTemplate.Transactions {
 		copy(txHashes[i+1][:], util.ReverseBuffer(util.HexToBytes(transaction.Hash)))
@@ -101,26 +169,31 @@ func (proxyServer *ProxyServer) fetchWork() {
 		copy(txMerkleTreeRootReversed[:], txHashes[0][:])
 	}
 
-	target, _ := new(big.Int).SetString(blockTemplate.Target, 16)
 	newWork := Work{
-		JobId:                util.GetHexTimestamp(),
+		JobId:                proxyServer.nextJobId(),
 		Version:              util.BytesToHex(util.PackUInt32LE(blockTemplate.Version)),
 		PrevHashReversed:     util.ReverseHex(blockTemplate.PrevBlockHash),
 		MerkleRootReversed:   util.BytesToHex(txMerkleTreeRootReversed[:]),
-		FinalSaplingRootHash: util.ReverseHex(blockTemplate.FinalSaplingRootHash),
+		FinalSaplingRootHash: finalSaplingRootHash,
 		Time:                 util.BytesToHex(util.PackUInt32LE(blockTemplate.CurTime)),
 		Bits:                 util.ReverseHex(blockTemplate.Bits),
 		Target:               blockTemplate.Target,
 		Height:               blockTemplate.Height,
-		Difficulty:           new(big.Int).Div(util.PowLimitTest, target),
-		CleanJobs:            true,
-		Template:             &blockTemplate,
-		GeneratedCoinbase:    coinbaseTxn,
+		Difficulty:           difficulty,
+		CleanJobs:            cleanJobs,
+		Template:             blockTemplate,
+		GeneratedCoinbase:    coinbase.Data,
 		FeeReward:            feeReward,
+		Payouts:              payouts,
+		CreatedAt:            time.Now(),
 	}
 
-	proxyServer.work.Store(&newWork)
-	log.Printf("New block to mine on %s at height %d", rpc.Name, blockTemplate.Height)
+	proxyServer.storeWork(&newWork)
+	if cleanJobs {
+		log.Printf("New block to mine on %s at height %d", rpc.Name, blockTemplate.Height)
+	} else {
+		log.Printf("Updated job on %s at height %d with %d transactions, fees %d", rpc.Name, blockTemplate.Height, len(blockTemplate.Transactions), feeReward)
+	}
 
 	// Stratum
 	if proxyServer.config.Proxy.Stratum.Enabled {
@@ -128,6 +201,155 @@ func (proxyServer *ProxyServer) fetchWork() {
 	}
 }
 
+// Same block template with a changed transaction set, worth a new job if it adds
+// enough fees or the current job is old enough to pick up whatever arrived
+func (proxyServer *ProxyServer) isTemplateUpdated(currentWork *Work, blockTemplate *BlockTemplate, feeReward int64) bool {
+	if blockTemplate.LongpollId == currentWork.Template.LongpollId {
+		return false
+	}
+	feeDelta := proxyServer.config.Proxy.JobRefreshFeeDelta
+	if feeDelta > 0 && feeReward-currentWork.FeeReward >= feeDelta {
+		return true
+	}
+	return proxyServer.jobRefreshInterval > 0 && time.Since(currentWork.CreatedAt) >= proxyServer.jobRefreshInterval
+}
+
+// Coinbase built by the node for its -mineraddress, used verbatim
+func daemonCoinbase(blockTemplate *BlockTemplate) (*transaction.Coinbase, string, error) {
+	if len(blockTemplate.CoinbaseTxn.Data) == 0 {
+		return nil, "", errors.New("template has no coinbase transaction, check -mineraddress of the node")
+	}
+
+	coinbase := &transaction.Coinbase{Data: util.HexToBytes(blockTemplate.CoinbaseTxn.Data)}
+	copy(coinbase.Hash[:], util.ReverseBuffer(util.HexToBytes(blockTemplate.CoinbaseTxn.Hash)))
+
+	if len(blockTemplate.DefaultRoots.BlockCommitmentsHash) > 0 {
+		return coinbase, util.ReverseHex(blockTemplate.DefaultRoots.BlockCommitmentsHash), nil
+	}
+	return coinbase, util.ReverseHex(blockTemplate.FinalSaplingRootHash), nil
+}
+
+// Coinbase paying the pool address, miners paid directly, founders and funding
+// streams, along with the header commitment matching it
+// Entries filling the window if every share had the smallest difficulty, doubled
+// like trimming after a block does to outlast difficulty changes
+func pplnsLimit(window float64, difficulty *big.Int, minShareDiff int64) int64 {
+	entries := new(big.Float).Mul(new(big.Float).SetInt(difficulty), big.NewFloat(window))
+	entries.Quo(entries, new(big.Float).SetInt64(minShareDiff))
+	limit, _ := entries.Int64()
+	return 2 * (limit + 1)
+}
+
+// Miner may choose a difficulty below varDiff bounds, PPLNS limit must cover its shares
+func (proxyServer *ProxyServer) lowerMinShareDiff(diff int64) {
+	for {
+		current := atomic.LoadInt64(&proxyServer.minShareDiff)
+		if diff >= current || atomic.CompareAndSwapInt64(&proxyServer.minShareDiff, current, diff) {
+			return
+		}
+	}
+}
+
+// Node provided parameters of coinbase, same for every template on top of the same block
+type coinbaseParams struct {
+	height         int64
+	prevHash       string
+	fundingStreams []transaction.Output
+	branchId       uint32
+}
+
+// Fetches coinbase parameters once per block, cached under workMu
+func (proxyServer *ProxyServer) coinbaseParams(rpc *rpc.RPCClient, blockTemplate *BlockTemplate) (*coinbaseParams, error) {
+	cached := proxyServer.lastCoinbaseParams
+	if cached != nil && cached.height == blockTemplate.Height && cached.prevHash == blockTemplate.PrevBlockHash {
+		return cached, nil
+	}
+	params, err := fetchCoinbaseParams(rpc, blockTemplate)
+	if err != nil {
+		return nil, err
+	}
+	proxyServer.lastCoinbaseParams = params
+	return params, nil
+}
+
+func fetchCoinbaseParams(rpc *rpc.RPCClient, blockTemplate *BlockTemplate) (*coinbaseParams, error) {
+	network := util.ActiveNetwork()
+	params := &coinbaseParams{height: blockTemplate.Height, prevHash: blockTemplate.PrevBlockHash}
+
+	// Dev fund recipients are taken from the node as they change with upgrades
+	if network.FundingStreamsReward(blockTemplate.Height) > 0 {
+		subsidy, err := rpc.GetBlockSubsidy(blockTemplate.Height)
+		if err != nil {
+			return nil, err
+		}
+		for _, stream := range subsidy.FundingStreams {
+			// Lockbox stream of NU6 has no address, its share is deferred rather than paid
+			if len(stream.Address) == 0 {
+				continue
+			}
+			params.fundingStreams = append(params.fundingStreams, transaction.Output{Address: stream.Address, Value: stream.ValueZat})
+		}
+	}
+
+	if network.IsNU5Active(blockTemplate.Height) {
+		info, err := rpc.GetBlockchainInfo()
+		if err != nil {
+			return nil, err
+		}
+		if params.branchId = util.HexToUInt32(info.Consensus.NextBlock); params.branchId == 0 {
+			return nil, errors.New("unknown consensus branch of the next block")
+		}
+	}
+	return params, nil
+}
+
+func buildCoinbase(params *coinbaseParams, blockTemplate *BlockTemplate, poolAddress string, feeReward int64, minerOutputs []transaction.Output) (*transaction.Coinbase, string, error) {
+	network := util.ActiveNetwork()
+	poolReward := util.GetConstReward(blockTemplate.Height).Int64() + feeReward
+	for _, output := range minerOutputs {
+		poolReward -= output.Value
+	}
+	outputs := append([]transaction.Output{{Address: poolAddress, Value: poolReward}}, minerOutputs...)
+
+	if network.IsFoundersRewardHeight(blockTemplate.Height) {
+		outputs = append(outputs, transaction.Output{
+			Address: network.FoundersRewardAddress(blockTemplate.Height),
+			Value:   blockTemplate.CoinbaseTxn.FoundersReward,
+		})
+	}
+
+	outputs = append(outputs, params.fundingStreams...)
+
+	branchId := params.branchId
+	var coinbase *transaction.Coinbase
+	var err error
+	if branchId != 0 {
+		coinbase, err = transaction.BuildCoinbaseTxnV5(blockTemplate.Height, branchId, outputs)
+	} else {
+		coinbase, err = transaction.BuildCoinbaseTxn(blockTemplate.Height, outputs)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Before NU5 the header root doesn't depend on the coinbase
+	if branchId == 0 {
+		return coinbase, util.ReverseHex(blockTemplate.FinalSaplingRootHash), nil
+	}
+
+	// Template commitments cover the node's own coinbase, recompute them for ours
+	authDigests := make([]chainhash.Hash, len(blockTemplate.Transactions)+1)
+	authDigests[0] = coinbase.AuthDigest
+	for i, transaction := range blockTemplate.Transactions {
+		copy(authDigests[i+1][:], util.ReverseBuffer(util.HexToBytes(transaction.AuthDigest)))
+	}
+
+	var chainHistoryRoot chainhash.Hash
+	copy(chainHistoryRoot[:], util.ReverseBuffer(util.HexToBytes(blockTemplate.DefaultRoots.ChainHistoryRoot)))
+	blockCommitments := transaction.BlockCommitmentsHash(chainHistoryRoot, transaction.AuthDataRoot(authDigests))
+	return coinbase, util.BytesToHex(blockCommitments[:]), nil
+}
+
 func (work *Work) BuildHeader(noncePart1, noncePart2 string) []byte {
 	result := util.HexToBytes(work.Version)
 	result = append(result, util.HexToBytes(work.PrevHashReversed)...)
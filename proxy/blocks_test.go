@@ -0,0 +1,115 @@
+package proxy
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jkkgbe/open-zcash-pool/rpc"
+	"github.com/jkkgbe/open-zcash-pool/transaction"
+	"github.com/jkkgbe/open-zcash-pool/util"
+)
+
+func TestBuildCoinbaseSkipsLockbox(t *testing.T) {
+	util.SetNetwork("mainnet")
+	defer util.SetNetwork("testnet")
+
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		switch {
+		case strings.Contains(string(body), "getblocksubsidy"):
+			w.Write([]byte(`{"id":0,"result":{"miner":1.25,"fundingstreams":[` +
+				`{"recipient":"Zcash Community Grants NU6","valueZat":12500000,"address":"t3LPohpJ3vwSLmJDG8KegAw6EPdaxa5V97b"},` +
+				`{"recipient":"Lockbox NU6","valueZat":18750000}]}}`))
+		case strings.Contains(string(body), "getblockchaininfo"):
+			w.Write([]byte(`{"id":0,"result":{"blocks":2726399,"consensus":{"chaintip":"c2d6d0b4","nextblock":"c8e71055"}}}`))
+		}
+	}))
+	defer node.Close()
+
+	blockTemplate := &BlockTemplate{Height: 2726400}
+	blockTemplate.DefaultRoots.ChainHistoryRoot = strings.Repeat("00", 32)
+	poolAddress := "t1HsdDMzmJfq4vc7T17XYjEkLMLvbgM1fCi"
+	params, err := fetchCoinbaseParams(rpc.NewRPCClient("test", node.URL, "1s"), blockTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	coinbase, _, err := buildCoinbase(params, blockTemplate, poolAddress, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want, err := transaction.BuildCoinbaseTxnV5(2726400, 0xc8e71055, []transaction.Output{
+		{Address: poolAddress, Value: 125000000},
+		{Address: "t3LPohpJ3vwSLmJDG8KegAw6EPdaxa5V97b", Value: 12500000},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(coinbase.Data, want.Data) {
+		t.Errorf("Unexpected coinbase %x", coinbase.Data)
+	}
+}
+
+func TestPPLNSLimit(t *testing.T) {
+	tests := []struct {
+		window       float64
+		difficulty   int64
+		minShareDiff int64
+		want         int64
+	}{
+		{2, 1000000, 1000, 4002},
+		{0.5, 1000000, 1000, 1002},
+		{2, 1000, 4096, 2},
+	}
+	for _, test := range tests {
+		if got := pplnsLimit(test.window, big.NewInt(test.difficulty), test.minShareDiff); got != test.want {
+			t.Errorf("pplnsLimit(%v, %v, %v) = %v, want %v", test.window, test.difficulty, test.minShareDiff, got, test.want)
+		}
+	}
+
+	proxyServer := &ProxyServer{minShareDiff: 256}
+	proxyServer.lowerMinShareDiff(1024)
+	proxyServer.lowerMinShareDiff(16)
+	if proxyServer.minShareDiff != 16 {
+		t.Errorf("Expected smallest share difficulty 16, got %v", proxyServer.minShareDiff)
+	}
+}
+
+func TestCoinbaseParamsCached(t *testing.T) {
+	util.SetNetwork("mainnet")
+	defer util.SetNetwork("testnet")
+
+	var calls int
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		body, _ := ioutil.ReadAll(r.Body)
+		switch {
+		case strings.Contains(string(body), "getblocksubsidy"):
+			w.Write([]byte(`{"id":0,"result":{"fundingstreams":[{"valueZat":12500000,"address":"t3LPohpJ3vwSLmJDG8KegAw6EPdaxa5V97b"}]}}`))
+		case strings.Contains(string(body), "getblockchaininfo"):
+			w.Write([]byte(`{"id":0,"result":{"consensus":{"nextblock":"c8e71055"}}}`))
+		}
+	}))
+	defer node.Close()
+	client := rpc.NewRPCClient("test", node.URL, "1s")
+
+	proxyServer := &ProxyServer{}
+	blockTemplate := &BlockTemplate{Height: 2726400, PrevBlockHash: "00ab"}
+	for i := 0; i < 3; i++ {
+		if _, err := proxyServer.coinbaseParams(client, blockTemplate); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("Expected parameters to be fetched once per block, got %d calls", calls)
+	}
+	proxyServer.coinbaseParams(client, &BlockTemplate{Height: 2726401, PrevBlockHash: "00cd"})
+	if calls != 4 {
+		t.Errorf("Expected parameters of next block to be fetched, got %d calls", calls)
+	}
+}
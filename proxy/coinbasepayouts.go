@@ -0,0 +1,94 @@
+package proxy
+
+import (
+	"log"
+	"math/big"
+	"sort"
+
+	"github.com/jkkgbe/open-zcash-pool/storage"
+	"github.com/jkkgbe/open-zcash-pool/transaction"
+	"github.com/jkkgbe/open-zcash-pool/util"
+)
+
+// Pays the PPLNS window straight from the coinbase, miners beyond MaxOutputs or
+// below Threshold zatoshi are credited to balances out of the pool output
+type CoinbasePayouts struct {
+	Enabled    bool    `json:"enabled"`
+	MaxOutputs int     `json:"maxOutputs"`
+	Threshold  int64   `json:"threshold"`
+	Fee        float64 `json:"fee"`
+}
+
+const defaultCoinbaseMaxOutputs = 50
+
+// Splits the job reward over the current PPLNS window, nil when nobody is in it
+func (proxyServer *ProxyServer) coinbasePayouts(height int64, reward int64, difficulty *big.Int) *storage.CoinbasePayouts {
+	window := new(big.Float).Mul(new(big.Float).SetInt(difficulty), big.NewFloat(proxyServer.config.Proxy.PPLNSWindow))
+	windowDiff, _ := window.Int64()
+	shares, err := proxyServer.backend.GetPPLNSShares(windowDiff)
+	if err != nil {
+		log.Printf("Failed to get PPLNS window for coinbase at height %d: %v", height, err)
+		return nil
+	}
+	if len(shares) == 0 {
+		return nil
+	}
+	cfg := proxyServer.config.Proxy.CoinbasePayouts
+	outputs, credits := splitCoinbaseRewards(shares, reward, cfg.Fee, cfg.MaxOutputs, cfg.Threshold)
+	return &storage.CoinbasePayouts{Reward: reward, Outputs: outputs, Credits: credits}
+}
+
+// Largest rewards become outputs, the rest is credited
+func splitCoinbaseRewards(shares map[string]int64, reward int64, fee float64, maxOutputs int, threshold int64) (map[string]int64, map[string]int64) {
+	var total int64
+	logins := make([]string, 0, len(shares))
+	for login, n := range shares {
+		total += n
+		logins = append(logins, login)
+	}
+
+	keep := new(big.Rat).Sub(big.NewRat(100, 1), new(big.Rat).SetFloat64(fee))
+	minersReward := new(big.Rat).Mul(new(big.Rat).SetInt64(reward), keep.Quo(keep, big.NewRat(100, 1)))
+
+	rewards := make(map[string]int64, len(shares))
+	for _, login := range logins {
+		amount := new(big.Rat).Mul(minersReward, big.NewRat(shares[login], total))
+		rewards[login] = new(big.Int).Quo(amount.Num(), amount.Denom()).Int64()
+	}
+	sort.Slice(logins, func(i, j int) bool {
+		if rewards[logins[i]] != rewards[logins[j]] {
+			return rewards[logins[i]] > rewards[logins[j]]
+		}
+		return logins[i] < logins[j]
+	})
+
+	outputs := make(map[string]int64)
+	credits := make(map[string]int64)
+	for _, login := range logins {
+		amount := rewards[login]
+		if amount <= 0 {
+			continue
+		}
+		if len(outputs) < maxOutputs && amount >= threshold && util.IsValidtAddress(login) {
+			outputs[login] = amount
+		} else {
+			credits[login] = amount
+		}
+	}
+	return outputs, credits
+}
+
+// Outputs in a stable order so equal payouts build equal coinbases
+func payoutOutputs(payouts *storage.CoinbasePayouts) []transaction.Output {
+	if payouts == nil {
+		return nil
+	}
+	outputs := make([]transaction.Output, 0, len(payouts.Outputs))
+	for login, amount := range payouts.Outputs {
+		outputs = append(outputs, transaction.Output{Address: login, Value: amount})
+	}
+	sort.Slice(outputs, func(i, j int) bool {
+		return outputs[i].Address < outputs[j].Address
+	})
+	return outputs
+}
@@ -0,0 +1,48 @@
+package proxy
+
+import (
+	"testing"
+
+	"github.com/jkkgbe/open-zcash-pool/util"
+)
+
+func TestSplitCoinbaseRewards(t *testing.T) {
+	network := util.ActiveNetwork()
+	first := "tmGoHHqgsCRuEna9YQX9zKp9ujeqGLMLEYi"
+	second := network.FoundersRewardAddress(1)
+	third := network.FoundersRewardAddress(network.HalvingHeight(1, 1) - 1)
+	if second == third {
+		t.Fatal("Expected distinct founders addresses")
+	}
+
+	shares := map[string]int64{
+		first:   500,
+		second:  300,
+		third:   150,
+		"login": 40,
+		"dust":  10,
+	}
+	outputs, credits := splitCoinbaseRewards(shares, 100000, 10, 2, 1000)
+
+	wantOutputs := map[string]int64{first: 45000, second: 27000}
+	wantCredits := map[string]int64{third: 13500, "login": 3600, "dust": 900}
+	if len(outputs) != len(wantOutputs) || len(credits) != len(wantCredits) {
+		t.Fatalf("Unexpected split %v %v", outputs, credits)
+	}
+	for login, amount := range wantOutputs {
+		if outputs[login] != amount {
+			t.Errorf("Output of %s: got %d, want %d", login, outputs[login], amount)
+		}
+	}
+	for login, amount := range wantCredits {
+		if credits[login] != amount {
+			t.Errorf("Credit of %s: got %d, want %d", login, credits[login], amount)
+		}
+	}
+
+	// Invalid address and dust are never outputs
+	outputs, credits = splitCoinbaseRewards(shares, 100000, 10, 10, 1000)
+	if len(outputs) != 3 || credits["login"] != 3600 || credits["dust"] != 900 {
+		t.Errorf("Unexpected split %v %v", outputs, credits)
+	}
+}
@@ -3,16 +3,20 @@ package proxy
 import (
 	"github.com/jkkgbe/open-zcash-pool/api"
 	"github.com/jkkgbe/open-zcash-pool/payouts"
+	"github.com/jkkgbe/open-zcash-pool/policy"
 	"github.com/jkkgbe/open-zcash-pool/storage"
 )
 
 type Config struct {
 	Name                  string        `json:"name"`
+	Network               string        `json:"network"`
+	CoinFile              string        `json:"coinFile"`
 	PoolAddress           string        `json:"poolAddress"`
 	Proxy                 Proxy         `json:"proxy"`
 	Api                   api.ApiConfig `json:"api"`
 	Upstream              []Upstream    `json:"upstream"`
 	UpstreamCheckInterval string        `json:"upstreamCheckInterval"`
+	UpstreamMaxLag        int64         `json:"upstreamMaxLag"`
 
 	Threads int `json:"threads"`
 
@@ -22,6 +26,8 @@ type Config struct {
 	Redis storage.Config `json:"redis"`
 
 	BlockUnlocker payouts.UnlockerConfig `json:"unlocker"`
+	Payouts       payouts.PayoutsConfig  `json:"payouts"`
+	Wallet        payouts.WalletConfig   `json:"wallet"`
 
 	NewrelicKey     string `json:"newrelicKey"`
 	NewrelicVerbose bool   `json:"newrelicVerbose"`
@@ -29,20 +35,39 @@ type Config struct {
 }
 
 type Proxy struct {
-	Enabled              bool   `json:"enabled"`
-	Listen               string `json:"listen"`
-	LimitHeadersSize     int    `json:"limitHeadersSize"`
-	LimitBodySize        int64  `json:"limitBodySize"`
-	BehindReverseProxy   bool   `json:"behindReverseProxy"`
-	BlockRefreshInterval string `json:"blockRefreshInterval"`
-	Difficulty           int64  `json:"difficulty"`
-	StateUpdateInterval  string `json:"stateUpdateInterval"`
-	HashrateExpiration   string `json:"hashrateExpiration"`
+	Enabled              bool    `json:"enabled"`
+	Listen               string  `json:"listen"`
+	LimitHeadersSize     int     `json:"limitHeadersSize"`
+	LimitBodySize        int64   `json:"limitBodySize"`
+	BehindReverseProxy   bool    `json:"behindReverseProxy"`
+	BlockRefreshInterval string  `json:"blockRefreshInterval"`
+	Difficulty           int64   `json:"difficulty"`
+	StateUpdateInterval  string  `json:"stateUpdateInterval"`
+	HashrateExpiration   string  `json:"hashrateExpiration"`
+	CoinbaseMode         string  `json:"coinbaseMode"`
+	RewardScheme         string  `json:"rewardScheme"`
+	PPLNSWindow          float64 `json:"pplnsWindow"`
+	PPSFee               float64 `json:"ppsFee"`
+	JobRefreshInterval   string  `json:"jobRefreshInterval"`
+	JobRefreshFeeDelta   int64   `json:"jobRefreshFeeDelta"`
 
 	MaxFails    int64 `json:"maxFails"`
 	HealthCheck bool  `json:"healthCheck"`
 
-	Stratum Stratum `json:"stratum"`
+	Policy          policy.Config   `json:"policy"`
+	Stratum         Stratum         `json:"stratum"`
+	VarDiff         VarDiff         `json:"varDiff"`
+	Longpoll        Longpoll        `json:"longpoll"`
+	BlockNotify     BlockNotify     `json:"blockNotify"`
+	CoinbasePayouts CoinbasePayouts `json:"coinbasePayouts"`
+	MinPayout       MinPayout       `json:"minPayout"`
+}
+
+// Bounds of personal payout threshold miners set by password, in Zatoshi
+type MinPayout struct {
+	Enabled bool  `json:"enabled"`
+	Min     int64 `json:"min"`
+	Max     int64 `json:"max"`
 }
 
 type Stratum struct {
@@ -53,7 +78,8 @@ type Stratum struct {
 }
 
 type Upstream struct {
-	Name    string `json:"name"`
-	Url     string `json:"url"`
-	Timeout string `json:"timeout"`
+	Name         string `json:"name"`
+	Url          string `json:"url"`
+	Timeout      string `json:"timeout"`
+	ZmqHashBlock string `json:"zmqHashBlock"`
 }
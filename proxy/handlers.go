@@ -1,8 +1,10 @@
 package proxy
 
 import (
+	"fmt"
 	"log"
 	"regexp"
+	"strings"
 
 	"github.com/jkkgbe/open-zcash-pool/util"
 )
@@ -11,7 +13,21 @@ import (
 var nTimePattern = regexp.MustCompile("^[0-9a-f]{8}$")
 var noncePattern = regexp.MustCompile("^[0-9a-f]{64}$")
 
-var workerPattern = regexp.MustCompile("^[0-9a-zA-Z-_]{1,8}$")
+var workerPattern = regexp.MustCompile("^[0-9a-zA-Z-_]{1,32}$")
+
+// Worker of miners sending plain address as username
+const defaultWorker = "0"
+
+// Login prefix choosing solo mining, blocks found pay the whole reward to the finder
+const soloLoginPrefix = "solo:"
+
+// Splits "address.worker" username sent by miner, worker is empty if there's none
+func splitLogin(username string) (string, string) {
+	if i := strings.IndexByte(username, '.'); i >= 0 {
+		return username[:i], username[i+1:]
+	}
+	return username, ""
+}
 
 func (proxyServer *ProxyServer) handleSubscribeRPC(session *Session, extraNonce1 string) []string {
 	session.extraNonce1 = extraNonce1
@@ -24,16 +40,116 @@ func (proxyServer *ProxyServer) handleAuthorizeRPC(session *Session, params []st
 		return false, &ErrorReply{Code: -1, Message: "Invalid params"}
 	}
 
-	login := params[0]
+	solo := strings.HasPrefix(params[0], soloLoginPrefix)
+	login, worker := splitLogin(strings.TrimPrefix(params[0], soloLoginPrefix))
 	if !util.IsValidLogin(login) {
 		return false, &ErrorReply{Code: -1, Message: "Invalid login"}
 	}
+	login = util.NormalizeLogin(login)
+	if len(worker) == 0 {
+		worker = defaultWorker
+	} else if !workerPattern.MatchString(worker) {
+		return false, &ErrorReply{Code: -1, Message: "Invalid worker name"}
+	}
+
+	var password string
+	if len(params) > 1 {
+		password = params[1]
+	}
+	options, err := parseAuthOptions(password)
+	if err != nil {
+		return false, &ErrorReply{Code: -1, Message: err.Error()}
+	}
+	solo = solo || options.solo
+	if errReply := proxyServer.checkMinPayout(options.minPayout); errReply != nil {
+		return false, errReply
+	}
+	if errReply := proxyServer.checkDifficulty(options.diff); errReply != nil {
+		return false, errReply
+	}
+
+	// Jobs are shared, their coinbase pays the whole window
+	if solo && proxyServer.config.Proxy.CoinbasePayouts.Enabled {
+		return false, &ErrorReply{Code: -1, Message: "Solo mining is disabled"}
+	}
+	if !proxyServer.policy.ApplyLoginPolicy(login, session.ip) {
+		return false, &ErrorReply{Code: -1, Message: "You are blacklisted"}
+	}
+	if options.minPayout > 0 {
+		proxyServer.setMinPayout(login, session.ip, options.minPayout)
+	}
 	session.login = login
+	session.worker = worker
+	session.solo = solo
+	if options.diff > 0 {
+		proxyServer.lowerMinShareDiff(options.diff)
+		session.initStaticDifficulty(options.diff)
+	} else {
+		session.initDifficulty(proxyServer.varDiff.clamp(proxyServer.config.Proxy.Difficulty))
+	}
 	proxyServer.registerSession(session)
-	log.Printf("Stratum miner connected %v@%v", login, session.ip)
+	if solo {
+		log.Printf("Stratum solo miner connected %v.%v@%v", login, worker, session.ip)
+	} else {
+		log.Printf("Stratum miner connected %v.%v@%v", login, worker, session.ip)
+	}
 	return true, nil
 }
 
+// Logins are not authenticated, so only miners already known may set threshold,
+// changes are logged with the IP to trace abuse
+func (proxyServer *ProxyServer) setMinPayout(login, ip string, amount int64) {
+	exists, err := proxyServer.backend.IsMinerExists(login)
+	if err != nil {
+		log.Printf("Failed to check miner %v for payout threshold: %v", login, err)
+		return
+	}
+	if !exists {
+		log.Printf("Ignoring payout threshold of unknown miner %v@%v", login, ip)
+		return
+	}
+	current, err := proxyServer.backend.GetMinPayout(login)
+	if err != nil {
+		log.Printf("Failed to get payout threshold of %v: %v", login, err)
+		return
+	}
+	if current == amount {
+		return
+	}
+	if err := proxyServer.backend.SetMinPayout(login, amount); err != nil {
+		log.Printf("Failed to set payout threshold of %v: %v", login, err)
+		return
+	}
+	log.Printf("Payout threshold of %v changed from %v to %v Zatoshi by %v", login, current, amount, ip)
+}
+
+// Difficulty chosen by miner must be within varDiff bounds, any goes without varDiff
+func (proxyServer *ProxyServer) checkDifficulty(diff int64) *ErrorReply {
+	policy := proxyServer.varDiff
+	if diff == 0 || !policy.enabled {
+		return nil
+	}
+	if diff < policy.minDiff || diff > policy.maxDiff {
+		return &ErrorReply{Code: -1, Message: fmt.Sprintf("Difficulty must be between %v and %v", policy.minDiff, policy.maxDiff)}
+	}
+	return nil
+}
+
+// Personal payout threshold must be within configured bounds
+func (proxyServer *ProxyServer) checkMinPayout(amount int64) *ErrorReply {
+	if amount == 0 {
+		return nil
+	}
+	bounds := proxyServer.config.Proxy.MinPayout
+	if !bounds.Enabled {
+		return &ErrorReply{Code: -1, Message: "Payout threshold option is disabled"}
+	}
+	if amount < bounds.Min || (bounds.Max > 0 && amount > bounds.Max) {
+		return &ErrorReply{Code: -1, Message: fmt.Sprintf("Payout threshold must be between %v and %v Zatoshi", bounds.Min, bounds.Max)}
+	}
+	return nil
+}
+
 func (proxyServer *ProxyServer) handleTCPSubmitRPC(session *Session, params []string, id string) (bool, *ErrorReply) {
 	proxyServer.sessionsMu.RLock()
 	_, ok := proxyServer.sessions[session]
@@ -45,35 +161,51 @@ func (proxyServer *ProxyServer) handleTCPSubmitRPC(session *Session, params []st
 	if session.extraNonce1 == "" {
 		return false, &ErrorReply{Code: 25, Message: "Not subscribed"}
 	}
+	// Peer may be banned by another session, its shares are no longer credited
+	if proxyServer.policy.IsBanned(session.ip) {
+		return false, &ErrorReply{Code: -1, Message: "You are banned"}
+	}
+	// Worker field of request is only honored for miners not naming one in username
+	if session.worker != defaultWorker || len(id) == 0 {
+		id = session.worker
+	}
 	return proxyServer.handleSubmitRPC(session, params, id)
 }
 
 func (proxyServer *ProxyServer) handleSubmitRPC(session *Session, params []string, id string) (bool, *ErrorReply) {
 	if !workerPattern.MatchString(id) {
-		id = "0"
+		id = defaultWorker
 	}
 
 	if len(params) != 5 {
+		proxyServer.policy.ApplyMalformedPolicy(session.ip)
 		log.Printf("Malformed params from %s@%s %v", session.login, session.ip, params)
 		return false, &ErrorReply{Code: -1, Message: "Invalid params"}
 	}
 
 	if !nTimePattern.MatchString(params[2]) {
+		proxyServer.policy.ApplyMalformedPolicy(session.ip)
 		log.Printf("Malformed nTime result from %s@%s %v", session.login, session.ip, params)
 		return false, &ErrorReply{Code: -1, Message: "Malformed nTime result"}
 	}
 
 	if !noncePattern.MatchString(session.extraNonce1 + params[3]) {
+		proxyServer.policy.ApplyMalformedPolicy(session.ip)
 		log.Printf("Malformed nonce result from %s@%s %v", session.login, session.ip, params)
 		return false, &ErrorReply{Code: -1, Message: "Malformed nonce result"}
 	}
 
-	if len(params[4]) != 2694 {
+	prefixLen, solutionSize := solutionLayout()
+	if solutionLen := 2 * (prefixLen + solutionSize); len(params[4]) != solutionLen {
+		proxyServer.policy.ApplyMalformedPolicy(session.ip)
 		log.Printf("Malformed solution result from %s@%s %v", session.login, session.ip, params)
-		return false, &ErrorReply{Code: -1, Message: "Malformed solution result, != 2694 length"}
+		return false, &ErrorReply{Code: -1, Message: fmt.Sprintf("Malformed solution result, != %d length", solutionLen)}
 	}
 
-	return proxyServer.processShare(session, id, params)
+	// Share credited before a ban is still accepted, session is closed after reply
+	reply, errReply := proxyServer.processShare(session, id, params)
+	proxyServer.policy.ApplySharePolicy(session.ip, errReply == nil)
+	return reply, errReply
 }
 
 func (proxyServer *ProxyServer) handleUnknownRPC(session *Session, method string) *ErrorReply {
@@ -0,0 +1,88 @@
+package proxy
+
+import (
+	"testing"
+
+	"github.com/jkkgbe/open-zcash-pool/policy"
+)
+
+func TestSplitLogin(t *testing.T) {
+	tests := []struct {
+		username string
+		login    string
+		worker   string
+	}{
+		{"tmGoHHqgsCRuEna9YQX9zKp9ujeqGLMLEYi", "tmGoHHqgsCRuEna9YQX9zKp9ujeqGLMLEYi", ""},
+		{"tmGoHHqgsCRuEna9YQX9zKp9ujeqGLMLEYi.rig1", "tmGoHHqgsCRuEna9YQX9zKp9ujeqGLMLEYi", "rig1"},
+		{"tmGoHHqgsCRuEna9YQX9zKp9ujeqGLMLEYi.rig.1", "tmGoHHqgsCRuEna9YQX9zKp9ujeqGLMLEYi", "rig.1"},
+		{"tmGoHHqgsCRuEna9YQX9zKp9ujeqGLMLEYi.", "tmGoHHqgsCRuEna9YQX9zKp9ujeqGLMLEYi", ""},
+	}
+	for _, test := range tests {
+		login, worker := splitLogin(test.username)
+		if login != test.login || worker != test.worker {
+			t.Errorf("splitLogin(%q) = %q, %q, want %q, %q", test.username, login, worker, test.login, test.worker)
+		}
+	}
+	if workerPattern.MatchString("rig.1") {
+		t.Error("Expected worker name with dot to be invalid")
+	}
+}
+
+func TestParseAuthOptions(t *testing.T) {
+	options, err := parseAuthOptions("d=4096, m=solo,minpay=0.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if options.diff != 4096 || !options.solo || options.minPayout != 50000000 {
+		t.Errorf("Unexpected options %+v", options)
+	}
+
+	for _, password := range []string{"", "x", "password"} {
+		options, err := parseAuthOptions(password)
+		if err != nil || *options != (authOptions{}) {
+			t.Errorf("Expected %q to set no options: %+v, %v", password, options, err)
+		}
+	}
+	for _, password := range []string{"d=0", "d=x", "m=pplns", "minpay=-1", "minpay=abc"} {
+		if _, err := parseAuthOptions(password); err == nil {
+			t.Errorf("Expected %q to be rejected", password)
+		}
+	}
+}
+
+func newAuthorizeTestServer(cfg *Config) *ProxyServer {
+	return &ProxyServer{
+		config:   cfg,
+		varDiff:  newVarDiffPolicy(&cfg.Proxy),
+		policy:   policy.Start(&cfg.Proxy.Policy, nil),
+		sessions: make(map[*Session]struct{}),
+	}
+}
+
+func TestAuthorizeDifficultyOption(t *testing.T) {
+	login := "tmGoHHqgsCRuEna9YQX9zKp9ujeqGLMLEYi"
+
+	// Without varDiff miner's difficulty is applied as given
+	proxyServer := newAuthorizeTestServer(&Config{Proxy: Proxy{Difficulty: 1000}})
+	session := &Session{ip: "1.1.1.1"}
+	if ok, errReply := proxyServer.handleAuthorizeRPC(session, []string{login, "d=50000"}); !ok || errReply != nil {
+		t.Fatalf("Expected authorize to succeed: %v", errReply)
+	}
+	if session.difficulty != 50000 || !session.staticDiff {
+		t.Errorf("Expected static difficulty 50000, got %v", session.difficulty)
+	}
+
+	proxyServer = newAuthorizeTestServer(&Config{Proxy: Proxy{
+		Difficulty: 1000,
+		VarDiff:    VarDiff{Enabled: true, MinDiff: 256, MaxDiff: 4096, TargetTime: "15s", RetargetTime: "90s"},
+	}})
+	for _, password := range []string{"d=100", "d=10000"} {
+		if ok, errReply := proxyServer.handleAuthorizeRPC(&Session{ip: "1.1.1.1"}, []string{login, password}); ok || errReply == nil {
+			t.Errorf("Expected %s out of varDiff bounds to be rejected", password)
+		}
+	}
+	session = &Session{ip: "1.1.1.1"}
+	if ok, _ := proxyServer.handleAuthorizeRPC(session, []string{login, "d=2048"}); !ok || session.difficulty != 2048 {
+		t.Errorf("Expected difficulty within bounds to be applied, got %v", session.difficulty)
+	}
+}
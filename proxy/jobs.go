@@ -0,0 +1,43 @@
+package proxy
+
+import (
+	"strconv"
+	"sync/atomic"
+)
+
+// Number of recent jobs kept for validating late shares
+const maxJobs = 16
+
+func (proxyServer *ProxyServer) nextJobId() string {
+	return strconv.FormatUint(atomic.AddUint64(&proxyServer.jobCounter, 1), 16)
+}
+
+// Makes work current and remembers it under its job id
+func (proxyServer *ProxyServer) storeWork(work *Work) {
+	proxyServer.jobsMu.Lock()
+	defer proxyServer.jobsMu.Unlock()
+
+	proxyServer.jobs[work.JobId] = work
+	proxyServer.jobIds = append(proxyServer.jobIds, work.JobId)
+	if len(proxyServer.jobIds) > maxJobs {
+		delete(proxyServer.jobs, proxyServer.jobIds[0])
+		proxyServer.jobIds = proxyServer.jobIds[1:]
+	}
+	proxyServer.work.Store(work)
+}
+
+// Work of the submitted job, nil together with a reply if it expired or
+// belongs to a previous block
+func (proxyServer *ProxyServer) findJob(jobId string) (*Work, *ErrorReply) {
+	proxyServer.jobsMu.RLock()
+	work, ok := proxyServer.jobs[jobId]
+	proxyServer.jobsMu.RUnlock()
+
+	if !ok {
+		return nil, &ErrorReply{Code: 21, Message: "Job not found"}
+	}
+	if currentWork := proxyServer.currentWork(); currentWork != nil && currentWork.PrevHashReversed != work.PrevHashReversed {
+		return nil, &ErrorReply{Code: 21, Message: "Stale share"}
+	}
+	return work, nil
+}
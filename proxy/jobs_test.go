@@ -0,0 +1,50 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFindJob(t *testing.T) {
+	proxyServer := &ProxyServer{jobs: make(map[string]*Work)}
+
+	first := &Work{JobId: proxyServer.nextJobId(), PrevHashReversed: "aa"}
+	proxyServer.storeWork(first)
+	if work, errReply := proxyServer.findJob(first.JobId); errReply != nil || work != first {
+		t.Errorf("current job not found: %v", errReply)
+	}
+
+	for i := 0; i < maxJobs-1; i++ {
+		proxyServer.storeWork(&Work{JobId: proxyServer.nextJobId(), PrevHashReversed: "aa"})
+	}
+	if _, errReply := proxyServer.findJob(first.JobId); errReply != nil {
+		t.Errorf("job within history not found: %v", errReply)
+	}
+
+	proxyServer.storeWork(&Work{JobId: proxyServer.nextJobId(), PrevHashReversed: "bb"})
+	if _, errReply := proxyServer.findJob(first.JobId); errReply == nil || errReply.Message != "Job not found" {
+		t.Errorf("expired job must not be found, got %v", errReply)
+	}
+	if _, errReply := proxyServer.findJob(proxyServer.jobIds[0]); errReply == nil || errReply.Message != "Stale share" {
+		t.Errorf("job for previous block must be stale, got %v", errReply)
+	}
+}
+
+func TestIsTemplateUpdated(t *testing.T) {
+	proxyServer := &ProxyServer{config: &Config{Proxy: Proxy{JobRefreshFeeDelta: 1000}}, jobRefreshInterval: time.Minute}
+	currentWork := &Work{Template: &BlockTemplate{LongpollId: "a1"}, FeeReward: 500, CreatedAt: time.Now()}
+
+	if proxyServer.isTemplateUpdated(currentWork, &BlockTemplate{LongpollId: "a1"}, 5000) {
+		t.Error("unchanged template must not refresh")
+	}
+	if proxyServer.isTemplateUpdated(currentWork, &BlockTemplate{LongpollId: "a2"}, 1000) {
+		t.Error("small fee delta must not refresh a recent job")
+	}
+	if !proxyServer.isTemplateUpdated(currentWork, &BlockTemplate{LongpollId: "a2"}, 1500) {
+		t.Error("fee delta must refresh")
+	}
+	currentWork.CreatedAt = time.Now().Add(-time.Minute)
+	if !proxyServer.isTemplateUpdated(currentWork, &BlockTemplate{LongpollId: "a2"}, 500) {
+		t.Error("old job must refresh")
+	}
+}
@@ -0,0 +1,71 @@
+package proxy
+
+import (
+	"log"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// Pause before retrying longpoll on upstream which failed or lacks support
+const longpollRetryInterval = 30 * time.Second
+
+type Longpoll struct {
+	Enabled bool   `json:"enabled"`
+	Timeout string `json:"timeout"`
+}
+
+// Waits on getblocktemplate longpoll and updates work as soon as the node
+// returns, interval polling takes over while longpoll doesn't work
+func (proxyServer *ProxyServer) longpollWork(timeout time.Duration) {
+	var longpollId string
+
+	for {
+		rpc := proxyServer.rpc()
+		if len(longpollId) == 0 {
+			if currentWork := proxyServer.currentWork(); currentWork != nil {
+				longpollId = currentWork.Template.LongpollId
+			}
+		}
+		if len(longpollId) == 0 {
+			proxyServer.setLongpolling(false)
+			time.Sleep(longpollRetryInterval)
+			continue
+		}
+
+		var blockTemplate BlockTemplate
+		err := rpc.GetBlockTemplateLongpoll(longpollId, timeout, &blockTemplate)
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			continue
+		}
+		if err != nil {
+			if proxyServer.isLongpolling() {
+				log.Printf("Longpoll on %s failed, falling back to interval polling: %v", rpc.Name, err)
+			}
+			proxyServer.setLongpolling(false)
+			longpollId = ""
+			time.Sleep(longpollRetryInterval)
+			continue
+		}
+
+		if !proxyServer.isLongpolling() {
+			log.Printf("Longpoll on %s is active", rpc.Name)
+			proxyServer.setLongpolling(true)
+		}
+		// Unchanged work still gets the latest id, otherwise node returns at once
+		longpollId = blockTemplate.LongpollId
+		proxyServer.updateWork(rpc, &blockTemplate)
+	}
+}
+
+func (proxyServer *ProxyServer) setLongpolling(active bool) {
+	var value int32
+	if active {
+		value = 1
+	}
+	atomic.StoreInt32(&proxyServer.longpolling, value)
+}
+
+func (proxyServer *ProxyServer) isLongpolling() bool {
+	return atomic.LoadInt32(&proxyServer.longpolling) == 1
+}
@@ -1,19 +1,36 @@
 package proxy
 
 import (
+	"fmt"
 	"log"
 	"math/big"
 	"strconv"
+	"time"
 
 	"github.com/jkkgbe/open-zcash-pool/equihash"
+	"github.com/jkkgbe/open-zcash-pool/rpc"
 	"github.com/jkkgbe/open-zcash-pool/util"
 )
 
 func (proxyServer *ProxyServer) processShare(session *Session, id string, params []string) (bool, *ErrorReply) {
 	extraNonce2 := params[3]
 	solution := params[4]
+	// Rounds are keyed by login, worker is credited separately
+	params[0] = session.login
+
+	work, errReply := proxyServer.findJob(params[1])
+	if errReply != nil {
+		proxyServer.writeStaleShare(session, params)
+		return false, errReply
+	}
+
+	// Shares are checked and credited at the difficulty their job was sent with
+	shareDiff, ok := session.jobDifficulty(params[1])
+	if !ok {
+		proxyServer.writeStaleShare(session, params)
+		return false, &ErrorReply{Code: 21, Message: "Job not found"}
+	}
 
-	work := proxyServer.currentWork()
 	header := work.BuildHeader(session.extraNonce1, extraNonce2)
 
 	headerWithSol := append(header, util.HexToBytes(solution)...)
@@ -34,26 +51,26 @@ func (proxyServer *ProxyServer) processShare(session *Session, id string, params
 			blockHex = append(blockHex, util.HexToBytes(transaction.Data)...)
 		}
 	} else {
-		if !isShareDiffGeDiff(headerWithSol, proxyServer.config.Proxy.Difficulty) {
+		if !isShareDiffGeDiff(headerWithSol, shareDiff) {
 			return false, &ErrorReply{Code: 23, Message: "Low difficulty share"}
 		}
 	}
 
-	ok, err := equihash.Verify(200, 9, header, util.HexToBytes(solution)[3:])
+	network := util.ActiveNetwork()
+	prefixLen, _ := solutionLayout()
+	ok, err := equihash.VerifyPersonalized(network.EquihashN, network.EquihashK, network.EquihashPersonalization, header, util.HexToBytes(solution)[prefixLen:])
 	if err != nil {
 		log.Println("Equihash verifier error:", err)
 	}
 	if ok {
 		if blockHex != nil {
-			_, err := proxyServer.rpc().SubmitBlock(util.BytesToHex(blockHex))
-			if err != nil {
-				return false, &ErrorReply{Code: 23, Message: "Submit block error"}
-			} else {
+			hash := util.Sha256d(headerWithSol)
+			blockHash := util.BytesToHex(util.ReverseBuffer(hash[:]))
+
+			if accepted, reason := proxyServer.submitBlock(util.BytesToHex(blockHex), blockHash, work.Height); accepted {
 				log.Printf("Block found by miner %v@%v at height %v", session.login, session.ip, work.Height)
 				proxyServer.fetchWork()
-				shareDiff := proxyServer.config.Proxy.Difficulty
-				blockHash := util.Sha256d(headerWithSol)
-				exists, err := proxyServer.backend.WriteBlock(session.login, id, params, shareDiff, work.Difficulty.Int64(), work.Height, proxyServer.hashrateExpiration, work.FeeReward, util.BytesToHex(util.ReverseBuffer(blockHash[:])))
+				exists, err := proxyServer.writeBlock(session, id, params, shareDiff, work, blockHash)
 
 				if exists {
 					return true, nil
@@ -66,10 +83,18 @@ func (proxyServer *ProxyServer) processShare(session *Session, id string, params
 				}
 
 				return true, nil
+			} else {
+				// Solution is still valid work of the miner, credit it as a share
+				log.Printf("Block %v by miner %v@%v at height %v rejected: %v", blockHash, session.login, session.ip, work.Height, reason)
+				if err := proxyServer.backend.WriteRejectedBlock(session.login, work.Height, blockHash, reason); err != nil {
+					log.Println("Failed to insert rejected block into backend:", err)
+				}
 			}
 		}
 
-		_, err := proxyServer.backend.WriteShare(session.login, id, params, proxyServer.config.Proxy.Difficulty, work.Height, proxyServer.hashrateExpiration)
+		session.trackShare(proxyServer.varDiff)
+
+		_, err := proxyServer.writeShare(session, id, params, shareDiff, work)
 		if err != nil {
 			log.Println("Failed to insert share data into backend:", err)
 		}
@@ -82,10 +107,134 @@ func (proxyServer *ProxyServer) processShare(session *Session, id string, params
 	}
 }
 
+func (proxyServer *ProxyServer) writeShare(session *Session, id string, params []string, shareDiff int64, work *Work) (bool, error) {
+	switch {
+	case session.solo:
+		return proxyServer.backend.WriteSoloShare(session.login, id, params, shareDiff, work.Height, proxyServer.hashrateExpiration)
+	case proxyServer.isPPS():
+		credit := proxyServer.ppsCredit(shareDiff, work)
+		return proxyServer.backend.WritePPSShare(session.login, id, params, shareDiff, work.Height, proxyServer.hashrateExpiration, credit)
+	default:
+		return proxyServer.backend.WriteShare(session.login, id, params, shareDiff, work.Height, proxyServer.hashrateExpiration)
+	}
+}
+
+func (proxyServer *ProxyServer) writeBlock(session *Session, id string, params []string, shareDiff int64, work *Work, blockHash string) (bool, error) {
+	switch {
+	case session.solo:
+		return proxyServer.backend.WriteSoloBlock(session.login, id, params, shareDiff, work.Difficulty.Int64(), work.Height, proxyServer.hashrateExpiration, work.FeeReward, blockHash)
+	case work.Payouts != nil:
+		return proxyServer.backend.WriteCoinbaseBlock(session.login, id, params, shareDiff, work.Difficulty.Int64(), work.Height, proxyServer.hashrateExpiration, work.FeeReward, blockHash, work.Payouts)
+	case proxyServer.isPPS():
+		credit := proxyServer.ppsCredit(shareDiff, work)
+		return proxyServer.backend.WritePPSBlock(session.login, id, params, shareDiff, work.Difficulty.Int64(), work.Height, proxyServer.hashrateExpiration, work.FeeReward, blockHash, credit)
+	default:
+		return proxyServer.backend.WriteBlock(session.login, id, params, shareDiff, work.Difficulty.Int64(), work.Height, proxyServer.hashrateExpiration, work.FeeReward, blockHash)
+	}
+}
+
+func (proxyServer *ProxyServer) isPPS() bool {
+	scheme := proxyServer.config.Proxy.RewardScheme
+	return scheme == RewardSchemePPS || scheme == RewardSchemeFPPS
+}
+
+// Expected value of the share in zatoshi less the PPS fee, FPPS also pays
+// the fees of the job's template
+func (proxyServer *ProxyServer) ppsCredit(shareDiff int64, work *Work) int64 {
+	reward := util.GetConstReward(work.Height)
+	if proxyServer.config.Proxy.RewardScheme == RewardSchemeFPPS {
+		reward.Add(reward, big.NewInt(work.FeeReward))
+	}
+	return ppsCredit(reward, shareDiff, work.Difficulty, proxyServer.config.Proxy.PPSFee)
+}
+
+func ppsCredit(blockReward *big.Int, shareDiff int64, networkDiff *big.Int, fee float64) int64 {
+	if networkDiff.Sign() <= 0 {
+		return 0
+	}
+	credit := new(big.Rat).SetFrac(new(big.Int).Mul(blockReward, big.NewInt(shareDiff)), networkDiff)
+	keep := new(big.Rat).Sub(big.NewRat(100, 1), new(big.Rat).SetFloat64(fee))
+	credit.Mul(credit, keep.Quo(keep, big.NewRat(100, 1)))
+	return new(big.Int).Quo(credit.Num(), credit.Denom()).Int64()
+}
+
+// Submits block to all healthy upstreams in parallel to speed up propagation,
+// it is accepted as soon as any of them accepts it, otherwise reason of the
+// last refusal is returned
+func (proxyServer *ProxyServer) submitBlock(blockHex, blockHash string, height int64) (bool, string) {
+	current := proxyServer.rpc()
+	var upstreams []*rpc.RPCClient
+	for _, upstream := range proxyServer.upstreams {
+		if upstream == current || !upstream.Sick() {
+			upstreams = append(upstreams, upstream)
+		}
+	}
+
+	reasons := make(chan string, len(upstreams))
+	for _, upstream := range upstreams {
+		go func(upstream *rpc.RPCClient) {
+			start := time.Now()
+			reason := submitBlockTo(upstream, blockHex, blockHash)
+			if len(reason) > 0 {
+				log.Printf("Block %v at height %v refused by %s in %v: %s", blockHash, height, upstream.Name, time.Since(start), reason)
+			} else {
+				log.Printf("Block %v at height %v accepted by %s in %v", blockHash, height, upstream.Name, time.Since(start))
+			}
+			reasons <- reason
+		}(upstream)
+	}
+
+	var reason string
+	for range upstreams {
+		if reason = <-reasons; len(reason) == 0 {
+			return true, ""
+		}
+	}
+	return false, reason
+}
+
+// Empty if node accepted the block, duplicate or inconclusive answers are
+// accepted only once getblock confirms the block is on the main chain
+func submitBlockTo(upstream *rpc.RPCClient, blockHex, blockHash string) string {
+	result, err := upstream.SubmitBlock(blockHex)
+	if err != nil {
+		return err.Error()
+	}
+	if result.IsAccepted() {
+		return ""
+	}
+	if !result.IsInconclusive() {
+		return string(result)
+	}
+
+	block, err := upstream.GetBlockByHash(blockHash)
+	if err != nil {
+		return fmt.Sprintf("%s, getblock: %v", result, err)
+	}
+	if block == nil || block.Confirmations < 1 {
+		return fmt.Sprintf("%s, not on main chain", result)
+	}
+	return ""
+}
+
+func (proxyServer *ProxyServer) writeStaleShare(session *Session, params []string) {
+	log.Printf("Stale share from %v@%v for job %v", session.login, session.ip, params[1])
+	if err := proxyServer.backend.WriteStaleShare(session.login); err != nil {
+		log.Println("Failed to insert stale share into backend:", err)
+	}
+}
+
+// Length of the compact size prefix and of the packed equihash solution
+func solutionLayout() (int, int) {
+	network := util.ActiveNetwork()
+	size := equihash.SolutionSize(network.EquihashN, network.EquihashK)
+	return len(util.PackVarInt(uint64(size))), size
+}
+
 func isShareDiffGeDiff(header []byte, minerDifficulty int64) bool {
 	headerHashed := util.Sha256d(header)
 	headerBig := new(big.Int).SetBytes(util.ReverseBuffer(headerHashed[:]))
-	shareDifficulty := new(big.Rat).SetFrac(util.PowLimitTest, headerBig)
+	shareDifficulty := new(big.Rat).SetFrac(util.ActiveNetwork().PowLimit, headerBig)
 	ratCmp := new(big.Rat).Quo(shareDifficulty, new(big.Rat).SetInt64(minerDifficulty)).Cmp(new(big.Rat).SetInt64(1))
 	diffOk := ratCmp >= 0
 
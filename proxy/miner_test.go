@@ -0,0 +1,65 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jkkgbe/open-zcash-pool/rpc"
+)
+
+func TestSubmitBlockTo(t *testing.T) {
+	tests := []struct {
+		submit  string
+		block   string
+		wantErr string
+	}{
+		{`null`, ``, ``},
+		{`"duplicate"`, `{"hash":"00ab","confirmations":1}`, ``},
+		{`"duplicate"`, `{"hash":"00ab","confirmations":-1}`, `duplicate, not on main chain`},
+		{`"inconclusive"`, ``, `inconclusive, getblock: Block not found`},
+		{`"bad-txnmrklroot"`, ``, `bad-txnmrklroot`},
+	}
+
+	for _, test := range tests {
+		node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			switch {
+			case strings.Contains(string(body), "submitblock"):
+				w.Write([]byte(`{"id":0,"result":` + test.submit + `}`))
+			case len(test.block) > 0:
+				w.Write([]byte(`{"id":0,"result":` + test.block + `}`))
+			default:
+				w.Write([]byte(`{"id":0,"result":null,"error":{"code":-5,"message":"Block not found"}}`))
+			}
+		}))
+		reason := submitBlockTo(rpc.NewRPCClient("test", node.URL, "1s"), "00", "00ab")
+		node.Close()
+
+		if reason != test.wantErr {
+			t.Errorf("submitblock %s: got %q, want %q", test.submit, reason, test.wantErr)
+		}
+	}
+}
+
+func TestPPSCredit(t *testing.T) {
+	tests := []struct {
+		reward, shareDiff, networkDiff int64
+		fee                            float64
+		want                           int64
+	}{
+		{312500000, 1000, 1000000, 0, 312500},
+		{312500000, 1000, 1000000, 2, 306250},
+		{312500000, 1, 3, 0, 104166666},
+		{312500000, 1000, 0, 0, 0},
+	}
+	for _, test := range tests {
+		credit := ppsCredit(big.NewInt(test.reward), test.shareDiff, big.NewInt(test.networkDiff), test.fee)
+		if credit != test.want {
+			t.Errorf("ppsCredit(%d, %d, %d, %v): got %d, want %d", test.reward, test.shareDiff, test.networkDiff, test.fee, credit, test.want)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package proxy
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/jkkgbe/open-zcash-pool/util"
+)
+
+// Options miner passes in mining.authorize password, e.g. "d=4096,m=solo,minpay=0.5".
+// Anything else, like the usual "x", is ignored.
+type authOptions struct {
+	diff      int64
+	solo      bool
+	minPayout int64
+}
+
+func parseAuthOptions(password string) (*authOptions, error) {
+	options := &authOptions{}
+	for _, option := range strings.Split(password, ",") {
+		i := strings.IndexByte(option, '=')
+		if i < 0 {
+			continue
+		}
+		key, value := strings.ToLower(strings.TrimSpace(option[:i])), strings.TrimSpace(option[i+1:])
+		switch key {
+		case "d":
+			diff, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || diff <= 0 {
+				return nil, errors.New("Invalid difficulty")
+			}
+			options.diff = diff
+		case "m":
+			if strings.ToLower(value) != "solo" {
+				return nil, errors.New("Invalid mining mode")
+			}
+			options.solo = true
+		case "minpay":
+			amount, err := util.ParseZec(value)
+			if err != nil || amount <= 0 {
+				return nil, errors.New("Invalid payout threshold")
+			}
+			options.minPayout = amount
+		}
+	}
+	return options, nil
+}
@@ -10,6 +10,8 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/jkkgbe/open-zcash-pool/equihash"
+	"github.com/jkkgbe/open-zcash-pool/policy"
 	"github.com/jkkgbe/open-zcash-pool/rpc"
 	"github.com/jkkgbe/open-zcash-pool/storage"
 	"github.com/jkkgbe/open-zcash-pool/util"
@@ -18,12 +20,22 @@ import (
 type ProxyServer struct {
 	config             *Config
 	work               atomic.Value
+	workMu             sync.Mutex
+	lastCoinbaseParams *coinbaseParams
+	longpolling        int32
+	jobCounter         uint64
+	jobsMu             sync.RWMutex
+	jobs               map[string]*Work
+	jobIds             []string
 	upstream           int32
 	upstreams          []*rpc.RPCClient
 	backend            *storage.RedisClient
-	diff               string
+	policy             *policy.PolicyServer
+	varDiff            *varDiffPolicy
 	hashrateExpiration time.Duration
+	jobRefreshInterval time.Duration
 	failsCount         int64
+	minShareDiff       int64
 
 	extraNonceCounter uint32
 
@@ -39,9 +51,22 @@ type Session struct {
 
 	// Stratum
 	sync.Mutex
+	closeOnce   sync.Once
 	conn        *net.TCPConn
 	login       string
+	worker      string
+	solo        bool
 	extraNonce1 string
+
+	// Vardiff
+	diffMu       sync.Mutex
+	difficulty   int64
+	staticDiff   bool
+	targetDiff   int64
+	jobDiffs     map[string]int64
+	jobIds       []string
+	windowStart  time.Time
+	windowShares int64
 }
 
 func NewProxy(cfg *Config, backend *storage.RedisClient) *ProxyServer {
@@ -49,19 +74,73 @@ func NewProxy(cfg *Config, backend *storage.RedisClient) *ProxyServer {
 		log.Fatal("You must set instance name")
 	}
 
+	network := util.ActiveNetwork()
+	if !util.IsValidtAddress(cfg.PoolAddress) {
+		log.Fatalf("Invalid poolAddress %s for %s", cfg.PoolAddress, network.Name)
+	}
+
+	switch cfg.Proxy.CoinbaseMode {
+	case "":
+		cfg.Proxy.CoinbaseMode = CoinbaseModeCustom
+	case CoinbaseModeCustom, CoinbaseModeDaemon:
+	default:
+		log.Fatalf("Unknown coinbaseMode %s, use %s or %s", cfg.Proxy.CoinbaseMode, CoinbaseModeCustom, CoinbaseModeDaemon)
+	}
+	log.Printf("Using %s coinbase transaction", cfg.Proxy.CoinbaseMode)
+
+	switch cfg.Proxy.RewardScheme {
+	case "", RewardSchemeProp:
+		cfg.Proxy.RewardScheme = RewardSchemeProp
+	case RewardSchemePPLNS:
+		if cfg.Proxy.PPLNSWindow <= 0 {
+			cfg.Proxy.PPLNSWindow = defaultPPLNSWindow
+		}
+		backend.EnablePPLNS(cfg.Proxy.PPLNSWindow)
+	case RewardSchemePPS, RewardSchemeFPPS:
+		log.Printf("Crediting shares with %v%% fee", cfg.Proxy.PPSFee)
+	default:
+		log.Fatalf("Unknown rewardScheme %s, use %s, %s, %s or %s", cfg.Proxy.RewardScheme, RewardSchemeProp, RewardSchemePPLNS, RewardSchemePPS, RewardSchemeFPPS)
+	}
+	log.Printf("Using %s reward scheme", cfg.Proxy.RewardScheme)
+
+	if cfg.Proxy.CoinbasePayouts.Enabled {
+		if cfg.Proxy.RewardScheme != RewardSchemePPLNS || cfg.Proxy.CoinbaseMode != CoinbaseModeCustom {
+			log.Fatalf("Coinbase payouts need %s reward scheme and %s coinbase", RewardSchemePPLNS, CoinbaseModeCustom)
+		}
+		if cfg.Proxy.CoinbasePayouts.MaxOutputs <= 0 {
+			cfg.Proxy.CoinbasePayouts.MaxOutputs = defaultCoinbaseMaxOutputs
+		}
+		log.Printf("Paying up to %d miners from coinbase, threshold %d zatoshi", cfg.Proxy.CoinbasePayouts.MaxOutputs, cfg.Proxy.CoinbasePayouts.Threshold)
+	}
+
+	if !equihash.IsSupported(network.EquihashN, network.EquihashK) {
+		log.Fatalf("Equihash %d,%d of %s is not supported", network.EquihashN, network.EquihashK, network.Name)
+	}
+
 	proxy := &ProxyServer{
 		config:             cfg,
 		upstreams:          make([]*rpc.RPCClient, len(cfg.Upstream)),
 		backend:            backend,
-		diff:               util.GetTargetHex(cfg.Proxy.Difficulty),
+		policy:             policy.Start(&cfg.Proxy.Policy, backend),
+		varDiff:            newVarDiffPolicy(&cfg.Proxy),
+		jobs:               make(map[string]*Work),
 		hashrateExpiration: util.MustParseDuration(cfg.Proxy.HashrateExpiration),
 
 		extraNonceCounter: util.CreateExtraNonceCounter(cfg.InstanceId),
 	}
+	proxy.minShareDiff = proxy.varDiff.clamp(proxy.varDiff.minDiff)
+
+	if len(cfg.Proxy.JobRefreshInterval) > 0 {
+		proxy.jobRefreshInterval = util.MustParseDuration(cfg.Proxy.JobRefreshInterval)
+		log.Printf("Set job refresh on new transactions every %v or %v zatoshi of fees", proxy.jobRefreshInterval, cfg.Proxy.JobRefreshFeeDelta)
+	}
 
 	for i, upstream := range cfg.Upstream {
 		proxy.upstreams[i] = rpc.NewRPCClient(upstream.Name, upstream.Url, upstream.Timeout)
 		log.Printf("Upstream: %s => %s", upstream.Name, upstream.Url)
+		if len(upstream.ZmqHashBlock) > 0 {
+			go proxy.watchHashBlock(upstream.Name, upstream.ZmqHashBlock)
+		}
 	}
 	log.Printf("Default upstream: %s => %s", proxy.rpc().Name, proxy.rpc().Url)
 
@@ -76,6 +155,16 @@ func NewProxy(cfg *Config, backend *storage.RedisClient) *ProxyServer {
 	refreshTimer := time.NewTimer(refreshInterval)
 	log.Printf("Set block refresh every %v", refreshInterval)
 
+	if cfg.Proxy.BlockNotify.Enabled {
+		go proxy.listenBlockNotify()
+	}
+
+	if cfg.Proxy.Longpoll.Enabled {
+		longpollTimeout := util.MustParseDuration(cfg.Proxy.Longpoll.Timeout)
+		log.Printf("Set block template longpoll with %v timeout", longpollTimeout)
+		go proxy.longpollWork(longpollTimeout)
+	}
+
 	checkInterval := util.MustParseDuration(cfg.UpstreamCheckInterval)
 	checkTimer := time.NewTimer(refreshInterval)
 
@@ -86,7 +175,9 @@ func NewProxy(cfg *Config, backend *storage.RedisClient) *ProxyServer {
 		for {
 			select {
 			case <-refreshTimer.C:
-				proxy.fetchWork()
+				if !proxy.isLongpolling() {
+					proxy.fetchWork()
+				}
 				refreshTimer.Reset(refreshInterval)
 			}
 		}
@@ -108,7 +199,7 @@ func NewProxy(cfg *Config, backend *storage.RedisClient) *ProxyServer {
 			case <-stateUpdateTimer.C:
 				currentWork := proxy.currentWork()
 				if currentWork != nil {
-					err := backend.WriteNodeState(cfg.Name, currentWork.Height, currentWork.Difficulty)
+					err := backend.WriteNodeState(cfg.Name, currentWork.Height, currentWork.Difficulty, proxy.upstreamStates())
 					if err != nil {
 						log.Printf("Failed to write node state to backend: %v", err)
 						proxy.markSick()
@@ -136,23 +227,6 @@ func (proxyServer *ProxyServer) rpc() *rpc.RPCClient {
 	return proxyServer.upstreams[i]
 }
 
-func (proxyServer *ProxyServer) checkUpstreams() {
-	candidate := int32(0)
-	backup := false
-
-	for i, upstream := range proxyServer.upstreams {
-		if upstream.Check() && !backup {
-			candidate = int32(i)
-			backup = true
-		}
-	}
-
-	if proxyServer.upstream != candidate {
-		log.Printf("Switching to %v upstream", proxyServer.upstreams[candidate].Name)
-		atomic.StoreInt32(&proxyServer.upstream, candidate)
-	}
-}
-
 func (proxyServer *ProxyServer) currentWork() *Work {
 	work := proxyServer.work.Load()
 	if work != nil {
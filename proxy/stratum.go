@@ -3,6 +3,7 @@ package proxy
 import (
 	"bufio"
 	"encoding/json"
+	"fmt"
 	"io"
 	"log"
 	"net"
@@ -42,16 +43,17 @@ func (proxyServer *ProxyServer) ListenTCP() {
 
 		ip, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
 
+		if proxyServer.policy.IsBanned(ip) || !proxyServer.policy.ApplyLimitPolicy(ip) {
+			conn.Close()
+			continue
+		}
 		n += 1
 		session := &Session{conn: conn, ip: ip}
 
 		accept <- n
 		go func(session *Session) {
-			err = proxyServer.handleTCPClient(session)
-			if err != nil {
-				proxyServer.removeSession(session)
-				conn.Close()
-			}
+			proxyServer.handleTCPClient(session)
+			proxyServer.removeSession(session)
 			<-accept
 		}(session)
 	}
@@ -80,6 +82,7 @@ func (proxyServer *ProxyServer) handleTCPClient(session *Session) error {
 			var req StratumReq
 			err = json.Unmarshal(data, &req)
 			if err != nil {
+				proxyServer.policy.ApplyMalformedPolicy(session.ip)
 				log.Printf("Malformed stratum request from %s: %v", session.ip, err)
 				return err
 			}
@@ -97,6 +100,7 @@ func (session *Session) handleTCPMessage(proxyServer *ProxyServer, req *StratumR
 	var params []string
 	err := json.Unmarshal(req.Params, &params)
 	if err != nil {
+		proxyServer.policy.ApplyMalformedPolicy(session.ip)
 		log.Println("Malformed stratum request params from", session.ip)
 		return err
 	}
@@ -115,14 +119,11 @@ func (session *Session) handleTCPMessage(proxyServer *ProxyServer, req *StratumR
 		}
 		session.sendTCPResult(req.Id, reply)
 
-		var diff = []interface{}{proxyServer.diff}
-		session.setTarget(&diff)
 		currentWork := proxyServer.currentWork()
 		if currentWork == nil || proxyServer.isSick() {
 			return nil
 		}
-		reply := currentWork.CreateJob()
-		return session.pushNewJob(&reply)
+		return proxyServer.sendJob(session, currentWork)
 	case "mining.submit":
 		reply, errReply = proxyServer.handleTCPSubmitRPC(session, params, req.Worker)
 	case "mining.extranonce.subscribe":
@@ -132,10 +133,15 @@ func (session *Session) handleTCPMessage(proxyServer *ProxyServer, req *StratumR
 	}
 
 	if errReply != nil {
-		return session.sendTCPError(req.Id, errReply)
+		err = session.sendTCPError(req.Id, errReply)
+	} else {
+		err = session.sendTCPResult(req.Id, reply)
 	}
-
-	return session.sendTCPResult(req.Id, reply)
+	// Share policy may have banned the peer, drop it after the reply
+	if err == nil && req.Method == "mining.submit" && proxyServer.policy.IsBanned(session.ip) {
+		return fmt.Errorf("banned peer %s", session.ip)
+	}
+	return err
 }
 
 func (session *Session) sendTCPResult(id json.RawMessage, result interface{}) error {
@@ -153,6 +159,20 @@ func (session *Session) setTarget(params *[]interface{}) error {
 	return session.enc.Encode(&message)
 }
 
+// Sends job along with a new target if session difficulty changed since last job
+func (proxyServer *ProxyServer) sendJob(session *Session, work *Work) error {
+	diff, changed := session.assignJob(work.JobId, proxyServer.varDiff)
+	if changed {
+		target := []interface{}{util.GetTargetHex(diff)}
+		if err := session.setTarget(&target); err != nil {
+			return err
+		}
+	}
+
+	reply := work.CreateJob()
+	return session.pushNewJob(&reply)
+}
+
 func (session *Session) pushNewJob(params *[]interface{}) error {
 	session.Lock()
 	defer session.Unlock()
@@ -178,10 +198,16 @@ func (proxyServer *ProxyServer) registerSession(session *Session) {
 	proxyServer.sessions[session] = struct{}{}
 }
 
+// Closes connection and gives its slot back to the IP limit, once per session
 func (proxyServer *ProxyServer) removeSession(session *Session) {
 	proxyServer.sessionsMu.Lock()
-	defer proxyServer.sessionsMu.Unlock()
 	delete(proxyServer.sessions, session)
+	proxyServer.sessionsMu.Unlock()
+
+	session.closeOnce.Do(func() {
+		session.conn.Close()
+		proxyServer.policy.ReleaseLimit(session.ip)
+	})
 }
 
 func (proxyServer *ProxyServer) broadcastNewJobs() {
@@ -190,7 +216,6 @@ func (proxyServer *ProxyServer) broadcastNewJobs() {
 	if currentWork == nil || proxyServer.isSick() {
 		return
 	}
-	reply := currentWork.CreateJob()
 
 	proxyServer.sessionsMu.RLock()
 	defer proxyServer.sessionsMu.RUnlock()
@@ -206,7 +231,7 @@ func (proxyServer *ProxyServer) broadcastNewJobs() {
 		bcast <- n
 
 		go func(session *Session) {
-			err := session.pushNewJob(&reply)
+			err := proxyServer.sendJob(session, currentWork)
 			<-bcast
 			if err != nil {
 				log.Printf("Job transmit error to %v@%v: %v", session.login, session.ip, err)
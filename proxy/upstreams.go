@@ -0,0 +1,125 @@
+package proxy
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jkkgbe/open-zcash-pool/rpc"
+	"github.com/jkkgbe/open-zcash-pool/storage"
+)
+
+// Blocks a node may trail the highest one before it's considered stuck
+const defaultUpstreamMaxLag = 3
+
+type upstreamStatus struct {
+	height  int64
+	latency time.Duration
+	sick    bool
+}
+
+func (proxyServer *ProxyServer) upstreamMaxLag() int64 {
+	if proxyServer.config.UpstreamMaxLag > 0 {
+		return proxyServer.config.UpstreamMaxLag
+	}
+	return defaultUpstreamMaxLag
+}
+
+func (proxyServer *ProxyServer) checkUpstreams() {
+	var wg sync.WaitGroup
+	for _, upstream := range proxyServer.upstreams {
+		wg.Add(1)
+		go func(upstream *rpc.RPCClient) {
+			defer wg.Done()
+			upstream.Check()
+		}(upstream)
+	}
+	wg.Wait()
+
+	statuses := proxyServer.upstreamStatuses()
+	maxHeight := maxUpstreamHeight(statuses)
+	maxLag := proxyServer.upstreamMaxLag()
+	for i, status := range statuses {
+		if !status.sick && isUpstreamBehind(status, maxHeight, maxLag) {
+			log.Printf("Upstream %v is stuck at height %v, %v blocks behind", proxyServer.upstreams[i].Name, status.height, maxHeight-status.height)
+		}
+	}
+
+	current := atomic.LoadInt32(&proxyServer.upstream)
+	candidate := selectUpstream(statuses, int(current), maxLag)
+	if int32(candidate) != current {
+		log.Printf("Switching to %v upstream at height %v, %v latency", proxyServer.upstreams[candidate].Name, statuses[candidate].height, statuses[candidate].latency)
+		atomic.StoreInt32(&proxyServer.upstream, int32(candidate))
+	}
+}
+
+func (proxyServer *ProxyServer) upstreamStatuses() []upstreamStatus {
+	statuses := make([]upstreamStatus, len(proxyServer.upstreams))
+	for i, upstream := range proxyServer.upstreams {
+		statuses[i] = upstreamStatus{height: upstream.Height(), latency: upstream.Latency(), sick: upstream.Sick()}
+	}
+	return statuses
+}
+
+func (proxyServer *ProxyServer) upstreamStates() []storage.UpstreamState {
+	statuses := proxyServer.upstreamStatuses()
+	maxHeight := maxUpstreamHeight(statuses)
+	current := int(atomic.LoadInt32(&proxyServer.upstream))
+
+	states := make([]storage.UpstreamState, len(statuses))
+	for i, status := range statuses {
+		states[i] = storage.UpstreamState{
+			Name:    proxyServer.upstreams[i].Name,
+			Height:  status.height,
+			Latency: int64(status.latency / time.Millisecond),
+			Sick:    status.sick,
+			Behind:  isUpstreamBehind(status, maxHeight, proxyServer.upstreamMaxLag()),
+			Active:  i == current,
+		}
+	}
+	return states
+}
+
+func maxUpstreamHeight(statuses []upstreamStatus) int64 {
+	var maxHeight int64
+	for _, status := range statuses {
+		if !status.sick && status.height > maxHeight {
+			maxHeight = status.height
+		}
+	}
+	return maxHeight
+}
+
+func isUpstreamBehind(status upstreamStatus, maxHeight, maxLag int64) bool {
+	return maxHeight-status.height > maxLag
+}
+
+// Prefers healthy node with the highest height and then the lowest latency,
+// current one is kept unless the other is higher or at least twice as fast
+func selectUpstream(statuses []upstreamStatus, current int, maxLag int64) int {
+	maxHeight := maxUpstreamHeight(statuses)
+
+	best := -1
+	for i, status := range statuses {
+		if status.sick || isUpstreamBehind(status, maxHeight, maxLag) {
+			continue
+		}
+		if best < 0 || status.height > statuses[best].height ||
+			(status.height == statuses[best].height && status.latency < statuses[best].latency) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return current
+	}
+
+	status := statuses[current]
+	if status.sick || isUpstreamBehind(status, maxHeight, maxLag) || statuses[best].height > status.height {
+		return best
+	}
+	if 2*statuses[best].latency <= status.latency {
+		return best
+	}
+	return current
+}
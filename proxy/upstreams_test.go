@@ -0,0 +1,29 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSelectUpstream(t *testing.T) {
+	ms := time.Millisecond
+	tests := []struct {
+		name     string
+		statuses []upstreamStatus
+		current  int
+		want     int
+	}{
+		{"keep healthy current", []upstreamStatus{{100, 20 * ms, false}, {100, 15 * ms, false}}, 0, 0},
+		{"twice as fast", []upstreamStatus{{100, 40 * ms, false}, {100, 15 * ms, false}}, 0, 1},
+		{"higher node", []upstreamStatus{{99, 5 * ms, false}, {100, 50 * ms, false}}, 0, 1},
+		{"sick current", []upstreamStatus{{100, 5 * ms, true}, {100, 50 * ms, false}}, 0, 1},
+		{"stuck node", []upstreamStatus{{100, 5 * ms, false}, {90, 1 * ms, false}}, 0, 0},
+		{"all sick", []upstreamStatus{{100, 5 * ms, true}, {100, 50 * ms, true}}, 1, 1},
+		{"sick node height ignored", []upstreamStatus{{200, 5 * ms, true}, {100, 50 * ms, false}}, 1, 1},
+	}
+	for _, test := range tests {
+		if got := selectUpstream(test.statuses, test.current, 3); got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
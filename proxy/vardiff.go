@@ -0,0 +1,161 @@
+package proxy
+
+import (
+	"log"
+	"time"
+
+	"github.com/jkkgbe/open-zcash-pool/util"
+)
+
+// Number of recently sent jobs a session remembers the difficulty of
+const maxSessionJobs = 8
+
+type VarDiff struct {
+	Enabled         bool    `json:"enabled"`
+	MinDiff         int64   `json:"minDiff"`
+	MaxDiff         int64   `json:"maxDiff"`
+	TargetTime      string  `json:"targetTime"`
+	RetargetTime    string  `json:"retargetTime"`
+	VariancePercent float64 `json:"variancePercent"`
+}
+
+type varDiffPolicy struct {
+	enabled         bool
+	minDiff         int64
+	maxDiff         int64
+	targetTime      time.Duration
+	retargetTime    time.Duration
+	variancePercent float64
+}
+
+func newVarDiffPolicy(cfg *Proxy) *varDiffPolicy {
+	policy := &varDiffPolicy{
+		enabled: cfg.VarDiff.Enabled,
+		minDiff: cfg.Difficulty,
+		maxDiff: cfg.Difficulty,
+	}
+	if !policy.enabled {
+		return policy
+	}
+
+	policy.minDiff = cfg.VarDiff.MinDiff
+	policy.maxDiff = cfg.VarDiff.MaxDiff
+	policy.targetTime = util.MustParseDuration(cfg.VarDiff.TargetTime)
+	policy.retargetTime = util.MustParseDuration(cfg.VarDiff.RetargetTime)
+	policy.variancePercent = cfg.VarDiff.VariancePercent
+	if policy.minDiff <= 0 || policy.minDiff > policy.maxDiff {
+		log.Fatalf("Invalid varDiff bounds %d..%d, need 0 < minDiff <= maxDiff", policy.minDiff, policy.maxDiff)
+	}
+	if policy.targetTime <= 0 || policy.retargetTime <= 0 {
+		log.Fatalf("Invalid varDiff targetTime %v and retargetTime %v, both must be positive", policy.targetTime, policy.retargetTime)
+	}
+	return policy
+}
+
+// Keeps difficulty within bounds and never below 1, zero target is undefined
+func (policy *varDiffPolicy) clamp(diff int64) int64 {
+	if diff < policy.minDiff {
+		diff = policy.minDiff
+	}
+	if policy.maxDiff > 0 && diff > policy.maxDiff {
+		diff = policy.maxDiff
+	}
+	if diff < 1 {
+		return 1
+	}
+	return diff
+}
+
+// Difficulty making the observed share rate match the target time, or the
+// current one if the window is too short or the change within variance
+func (policy *varDiffPolicy) retarget(diff, shares int64, elapsed time.Duration) int64 {
+	if !policy.enabled || elapsed < policy.retargetTime {
+		return diff
+	}
+	if shares < 1 {
+		shares = 1
+	}
+	if diff < 1 {
+		diff = 1
+	}
+
+	shareTime := float64(elapsed) / float64(shares)
+	newDiff := policy.clamp(int64(float64(diff) * float64(policy.targetTime) / shareTime))
+
+	change := float64(newDiff-diff) / float64(diff) * 100
+	if change < policy.variancePercent && change > -policy.variancePercent {
+		return diff
+	}
+	return newDiff
+}
+
+func (session *Session) initDifficulty(diff int64) {
+	session.diffMu.Lock()
+	defer session.diffMu.Unlock()
+
+	session.difficulty = diff
+	session.staticDiff = false
+	session.jobDiffs = make(map[string]int64)
+	session.windowStart = time.Now()
+	session.windowShares = 0
+}
+
+// Difficulty chosen by miner is never retargeted
+func (session *Session) initStaticDifficulty(diff int64) {
+	session.initDifficulty(diff)
+
+	session.diffMu.Lock()
+	defer session.diffMu.Unlock()
+	session.staticDiff = true
+}
+
+// Counts accepted share and retargets, new difficulty is used for the next job
+func (session *Session) trackShare(policy *varDiffPolicy) {
+	session.diffMu.Lock()
+	defer session.diffMu.Unlock()
+
+	session.windowShares++
+	session.retargetLocked(policy)
+}
+
+func (session *Session) retargetLocked(policy *varDiffPolicy) {
+	elapsed := time.Since(session.windowStart)
+	if !policy.enabled || session.staticDiff || elapsed < policy.retargetTime {
+		return
+	}
+
+	session.difficulty = policy.retarget(session.difficulty, session.windowShares, elapsed)
+	session.windowStart = time.Now()
+	session.windowShares = 0
+}
+
+// Assigns session difficulty to the job, reporting whether the target changed
+func (session *Session) assignJob(jobId string, policy *varDiffPolicy) (int64, bool) {
+	session.diffMu.Lock()
+	defer session.diffMu.Unlock()
+
+	// Idle miners never submit shares, so lower their difficulty here too
+	session.retargetLocked(policy)
+
+	diff := session.difficulty
+	if _, ok := session.jobDiffs[jobId]; !ok {
+		session.jobIds = append(session.jobIds, jobId)
+		if len(session.jobIds) > maxSessionJobs {
+			delete(session.jobDiffs, session.jobIds[0])
+			session.jobIds = session.jobIds[1:]
+		}
+	}
+	session.jobDiffs[jobId] = diff
+
+	changed := diff != session.targetDiff
+	session.targetDiff = diff
+	return diff, changed
+}
+
+func (session *Session) jobDifficulty(jobId string) (int64, bool) {
+	session.diffMu.Lock()
+	defer session.diffMu.Unlock()
+
+	diff, ok := session.jobDiffs[jobId]
+	return diff, ok
+}
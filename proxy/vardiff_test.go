@@ -0,0 +1,98 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVarDiffRetarget(t *testing.T) {
+	policy := &varDiffPolicy{
+		enabled:         true,
+		minDiff:         256,
+		maxDiff:         4096,
+		targetTime:      15 * time.Second,
+		retargetTime:    90 * time.Second,
+		variancePercent: 30,
+	}
+
+	tests := []struct {
+		diff    int64
+		shares  int64
+		elapsed time.Duration
+		want    int64
+	}{
+		{1024, 6, 60 * time.Second, 1024},
+		{1024, 6, 90 * time.Second, 1024},
+		{1024, 7, 90 * time.Second, 1024},
+		{1024, 12, 90 * time.Second, 2048},
+		{1024, 3, 90 * time.Second, 512},
+		{1024, 0, 90 * time.Second, 256},
+		{1024, 600, 90 * time.Second, 4096},
+	}
+	for _, test := range tests {
+		if got := policy.retarget(test.diff, test.shares, test.elapsed); got != test.want {
+			t.Errorf("retarget(%v, %v, %v) = %v, want %v", test.diff, test.shares, test.elapsed, got, test.want)
+		}
+	}
+}
+
+func TestVarDiffJobHistory(t *testing.T) {
+	policy := &varDiffPolicy{minDiff: 256, maxDiff: 256}
+	session := &Session{}
+	session.initDifficulty(256)
+
+	if _, changed := session.assignJob("1", policy); !changed {
+		t.Error("first job must set target")
+	}
+	if _, changed := session.assignJob("2", policy); changed {
+		t.Error("target unchanged for same difficulty")
+	}
+	for i := 3; i <= maxSessionJobs+2; i++ {
+		session.assignJob(string(rune('0'+i)), policy)
+	}
+	if _, ok := session.jobDifficulty("1"); ok {
+		t.Error("oldest job must be forgotten")
+	}
+	if diff, ok := session.jobDifficulty("2"); ok || diff != 0 {
+		t.Error("second job must be forgotten")
+	}
+	if diff, ok := session.jobDifficulty("3"); !ok || diff != 256 {
+		t.Errorf("job 3 difficulty = %v, %v", diff, ok)
+	}
+}
+
+func TestStaticDifficulty(t *testing.T) {
+	policy := &varDiffPolicy{
+		enabled:         true,
+		minDiff:         256,
+		maxDiff:         4096,
+		targetTime:      15 * time.Second,
+		retargetTime:    90 * time.Second,
+		variancePercent: 30,
+	}
+	session := &Session{}
+	session.initStaticDifficulty(1024)
+	session.windowStart = time.Now().Add(-2 * policy.retargetTime)
+
+	if diff, _ := session.assignJob("1", policy); diff != 1024 {
+		t.Errorf("static difficulty retargeted to %v", diff)
+	}
+}
+
+func TestVarDiffNeverZero(t *testing.T) {
+	policy := &varDiffPolicy{
+		enabled:         true,
+		targetTime:      15 * time.Second,
+		retargetTime:    90 * time.Second,
+		variancePercent: 30,
+	}
+	if got := policy.retarget(1, 0, time.Hour); got != 1 {
+		t.Errorf("retarget of idle session = %v, want 1", got)
+	}
+	if got := policy.retarget(0, 10, 90*time.Second); got < 1 {
+		t.Errorf("retarget of zero difficulty = %v", got)
+	}
+	if got := (&varDiffPolicy{}).clamp(0); got != 1 {
+		t.Errorf("clamp(0) = %v, want 1", got)
+	}
+}
@@ -0,0 +1,65 @@
+package proxy
+
+import (
+	"encoding/hex"
+	"log"
+	"net"
+	"time"
+
+	"github.com/lightninglabs/gozmq"
+)
+
+const (
+	zmqHashBlockTopic = "hashblock"
+	zmqReadTimeout    = 30 * time.Second
+	zmqMinBackoff     = time.Second
+	zmqMaxBackoff     = 2 * time.Minute
+)
+
+// Refreshes work the moment upstream publishes a new block, interval polling
+// keeps running meanwhile in case a notification is lost
+func (proxyServer *ProxyServer) watchHashBlock(name, addr string) {
+	backoff := zmqMinBackoff
+
+	for {
+		conn, err := gozmq.Subscribe(addr, []string{zmqHashBlockTopic}, zmqReadTimeout)
+		if err != nil {
+			log.Printf("Failed to subscribe to %s hashblock on %s, retrying in %v: %v", name, addr, backoff, err)
+			time.Sleep(backoff)
+			backoff *= 2
+			if backoff > zmqMaxBackoff {
+				backoff = zmqMaxBackoff
+			}
+			continue
+		}
+		backoff = zmqMinBackoff
+		log.Printf("Subscribed to %s hashblock on %s", name, addr)
+
+		err = receiveHashBlocks(conn, func(blockHash string) {
+			log.Printf("New block %s announced by %s", blockHash, name)
+			proxyServer.fetchWork()
+		})
+		conn.Close()
+		log.Printf("Lost %s hashblock subscription: %v", name, err)
+	}
+}
+
+// Reads notifications until the subscription breaks, gozmq reports idle
+// periods and its own reconnect attempts as timeouts
+func receiveHashBlocks(conn *gozmq.Conn, notify func(blockHash string)) error {
+	for {
+		msg, err := conn.Receive(nil)
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		// Topic, block hash and sequence number
+		if len(msg) < 2 || string(msg[0]) != zmqHashBlockTopic {
+			continue
+		}
+		notify(hex.EncodeToString(msg[1]))
+	}
+}
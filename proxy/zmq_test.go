@@ -0,0 +1,97 @@
+package proxy
+
+import (
+	"encoding/hex"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/lightninglabs/gozmq"
+)
+
+// Minimal ZMTP 3.0 publisher speaking the NULL mechanism
+func servePublisher(t *testing.T, listener net.Listener, parts [][]byte) {
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+
+	greeting := make([]byte, 64)
+	if _, err := io.ReadFull(conn, greeting); err != nil {
+		t.Error(err)
+		return
+	}
+	greeting = make([]byte, 64)
+	copy(greeting, []byte{0xff, 0, 0, 0, 0, 0, 0, 0, 0, 0x7f, 3, 0, 'N', 'U', 'L', 'L'})
+	conn.Write(greeting)
+
+	readFrame := func() []byte {
+		header := make([]byte, 2)
+		if _, err := io.ReadFull(conn, header); err != nil {
+			t.Error(err)
+			return nil
+		}
+		body := make([]byte, header[1])
+		io.ReadFull(conn, body)
+		return body
+	}
+	writeFrame := func(flag byte, body []byte) {
+		conn.Write(append([]byte{flag, byte(len(body))}, body...))
+	}
+
+	// Client READY, our READY and client subscription
+	readFrame()
+	ready := append([]byte{5}, "READY"...)
+	ready = append(ready, 11)
+	ready = append(ready, "Socket-Type"...)
+	ready = append(ready, 0, 0, 0, 3)
+	ready = append(ready, "PUB"...)
+	writeFrame(4, ready)
+	if subscription := readFrame(); string(subscription) != "\x01"+zmqHashBlockTopic {
+		t.Errorf("unexpected subscription %q", subscription)
+	}
+
+	for i, part := range parts {
+		var flag byte = 1
+		if i == len(parts)-1 {
+			flag = 0
+		}
+		writeFrame(flag, part)
+	}
+	time.Sleep(100 * time.Millisecond)
+}
+
+func TestReceiveHashBlocks(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	blockHash := "0000000001c52a4ea79f1d6cc1b7c2b2b7b0c6f7d9b3b9c6e5b2a2b6c3d4e5f6"
+	hash, _ := hex.DecodeString(blockHash)
+	go servePublisher(t, listener, [][]byte{[]byte(zmqHashBlockTopic), hash, {1, 0, 0, 0}})
+
+	conn, err := gozmq.Subscribe("tcp://"+listener.Addr().String(), []string{zmqHashBlockTopic}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	notified := make(chan string, 1)
+	go receiveHashBlocks(conn, func(blockHash string) {
+		notified <- blockHash
+		conn.Close()
+	})
+
+	select {
+	case got := <-notified:
+		if got != blockHash {
+			t.Errorf("got block %s, want %s", got, blockHash)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no hashblock notification")
+	}
+}
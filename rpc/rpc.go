@@ -3,10 +3,10 @@ package rpc
 import (
 	"bytes"
 	"encoding/json"
-	"errors"
 	"net/http"
 	"strconv"
 	"sync"
+	"time"
 
 	"github.com/jkkgbe/open-zcash-pool/util"
 )
@@ -31,6 +31,117 @@ type GetBlockReply struct {
 	Difficulty    float64 `json:"difficulty"`
 }
 
+type GetTransactionReply struct {
+	Txid          string      `json:"txid"`
+	Confirmations int64       `json:"confirmations"`
+	Time          int64       `json:"time"`
+	Fee           json.Number `json:"fee"`
+}
+
+type WalletTransaction struct {
+	Txid     string `json:"txid"`
+	Category string `json:"category"`
+	Comment  string `json:"comment"`
+	Time     int64  `json:"time"`
+}
+
+type GetBlockchainInfoReply struct {
+	Blocks    int64 `json:"blocks"`
+	Consensus struct {
+		ChainTip  string `json:"chaintip"`
+		NextBlock string `json:"nextblock"`
+	} `json:"consensus"`
+}
+
+type FundingStream struct {
+	Recipient string `json:"recipient"`
+	ValueZat  int64  `json:"valueZat"`
+	Address   string `json:"address"`
+}
+
+type GetBlockSubsidyReply struct {
+	Miner          float64         `json:"miner"`
+	Founders       float64         `json:"founders"`
+	FundingStreams []FundingStream `json:"fundingstreams"`
+}
+
+type Unspent struct {
+	Txid          string      `json:"txid"`
+	Vout          int         `json:"vout"`
+	Address       string      `json:"address"`
+	Amount        json.Number `json:"amount"`
+	Confirmations int64       `json:"confirmations"`
+	Generated     bool        `json:"generated"`
+}
+
+type ZShieldCoinbaseReply struct {
+	RemainingUTXOs int         `json:"remainingUTXOs"`
+	RemainingValue json.Number `json:"remainingValue"`
+	ShieldingUTXOs int         `json:"shieldingUTXOs"`
+	ShieldingValue json.Number `json:"shieldingValue"`
+	Opid           string      `json:"opid"`
+}
+
+type ZSendManyAmount struct {
+	Address string      `json:"address"`
+	Amount  json.Number `json:"amount"`
+}
+
+// Async wallet operation as reported by z_getoperationstatus
+type ZOperation struct {
+	Id     string `json:"id"`
+	Status string `json:"status"`
+	Result *struct {
+		Txid string `json:"txid"`
+	} `json:"result"`
+	Error *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+const (
+	ZOperationQueued    = "queued"
+	ZOperationExecuting = "executing"
+	ZOperationSuccess   = "success"
+	ZOperationFailed    = "failed"
+	ZOperationCancelled = "cancelled"
+)
+
+func (op *ZOperation) IsFinished() bool {
+	return op.Status != ZOperationQueued && op.Status != ZOperationExecuting
+}
+
+// Error returned by the node itself, as opposed to transport failures
+type Error struct {
+	Code    int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Rejection reason returned by submitblock (BIP 22)
+type SubmitBlockResult string
+
+const (
+	SubmitBlockAccepted     SubmitBlockResult = ""
+	SubmitBlockDuplicate    SubmitBlockResult = "duplicate"
+	SubmitBlockInconclusive SubmitBlockResult = "inconclusive"
+	SubmitBlockRejected     SubmitBlockResult = "rejected"
+)
+
+func (result SubmitBlockResult) IsAccepted() bool {
+	return result == SubmitBlockAccepted
+}
+
+// Node already has the block or didn't validate it to the end,
+// whether it made it into the chain has to be checked separately
+func (result SubmitBlockResult) IsInconclusive() bool {
+	return result == SubmitBlockDuplicate || result == SubmitBlockInconclusive
+}
+
 type RPCClient struct {
 	sync.RWMutex
 	Url         string
@@ -38,6 +149,8 @@ type RPCClient struct {
 	sick        bool
 	sickRate    int
 	successRate int
+	height      int64
+	latency     time.Duration
 	client      *http.Client
 }
 
@@ -92,22 +205,181 @@ func (r *RPCClient) GetBlockTemplate(reply interface{}) error {
 	return json.Unmarshal(*rpcResp.Result, reply)
 }
 
-func (r *RPCClient) SubmitBlock(header string) (interface{}, error) {
-	rpcResp, err := r.doPost(r.Url, "submitblock", []string{header})
+// Blocks until the node has a template different from longpollId, a new block
+// or changed mempool, or the timeout elapses
+func (r *RPCClient) GetBlockTemplateLongpoll(longpollId string, timeout time.Duration, reply interface{}) error {
+	client := &http.Client{Timeout: timeout}
+	params := []interface{}{map[string]string{"longpollid": longpollId}}
+	rpcResp, err := r.doPostWithClient(client, r.Url, "getblocktemplate", params)
+	if err != nil {
+		return err
+	}
 
+	return json.Unmarshal(*rpcResp.Result, reply)
+}
+
+func (r *RPCClient) GetBlockchainInfo() (*GetBlockchainInfoReply, error) {
+	rpcResp, err := r.doPost(r.Url, "getblockchaininfo", []string{})
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 
-	var reply interface{}
+	var reply *GetBlockchainInfoReply
 	if rpcResp.Result != nil {
-		err = json.Unmarshal(*rpcResp.Result, reply)
+		err = json.Unmarshal(*rpcResp.Result, &reply)
+	}
+	return reply, err
+}
+
+func (r *RPCClient) GetBlockSubsidy(height int64) (*GetBlockSubsidyReply, error) {
+	rpcResp, err := r.doPost(r.Url, "getblocksubsidy", []int64{height})
+	if err != nil {
+		return nil, err
 	}
 
+	var reply *GetBlockSubsidyReply
+	if rpcResp.Result != nil {
+		err = json.Unmarshal(*rpcResp.Result, &reply)
+	}
+	return reply, err
+}
+
+func (r *RPCClient) GetBlockByHash(hash string) (*GetBlockReply, error) {
+	rpcResp, err := r.doPost(r.Url, "getblock", []string{hash})
+	if err != nil {
+		return nil, err
+	}
+
+	var reply *GetBlockReply
+	if rpcResp.Result != nil {
+		err = json.Unmarshal(*rpcResp.Result, &reply)
+	}
+	return reply, err
+}
+
+func (r *RPCClient) SubmitBlock(blockHex string) (SubmitBlockResult, error) {
+	rpcResp, err := r.doPost(r.Url, "submitblock", []string{blockHex})
+	if err != nil {
+		return "", err
+	}
+
+	// Null result means the block was accepted
+	var reply SubmitBlockResult = SubmitBlockAccepted
+	if rpcResp.Result != nil {
+		err = json.Unmarshal(*rpcResp.Result, &reply)
+	}
+	return reply, err
+}
+
+func (r *RPCClient) SendMany(amounts map[string]json.Number, minConf int64, comment string) (string, error) {
+	rpcResp, err := r.doPost(r.Url, "sendmany", []interface{}{"", amounts, minConf, comment})
+	if err != nil {
+		return "", err
+	}
+
+	var reply string
+	err = json.Unmarshal(*rpcResp.Result, &reply)
+	return reply, err
+}
+
+// Starts shielded send and returns its operation id, empty privacy policy keeps node default
+func (r *RPCClient) ZSendMany(from string, amounts []ZSendManyAmount, minConf int64, privacyPolicy string) (string, error) {
+	params := []interface{}{from, amounts, minConf}
+	if len(privacyPolicy) > 0 {
+		params = append(params, nil, privacyPolicy)
+	}
+	rpcResp, err := r.doPost(r.Url, "z_sendmany", params)
+	if err != nil {
+		return "", err
+	}
+
+	var reply string
+	err = json.Unmarshal(*rpcResp.Result, &reply)
+	return reply, err
+}
+
+func (r *RPCClient) ListUnspent(minConf int64, addresses []string) ([]Unspent, error) {
+	rpcResp, err := r.doPost(r.Url, "listunspent", []interface{}{minConf, 9999999, addresses})
+	if err != nil {
+		return nil, err
+	}
+
+	var reply []Unspent
+	if rpcResp.Result != nil {
+		err = json.Unmarshal(*rpcResp.Result, &reply)
+	}
+	return reply, err
+}
+
+// Starts shielding up to limit coinbase UTXOs of from, node picks the default fee
+func (r *RPCClient) ZShieldCoinbase(from, to string, limit int) (*ZShieldCoinbaseReply, error) {
+	rpcResp, err := r.doPost(r.Url, "z_shieldcoinbase", []interface{}{from, to, nil, limit})
+	if err != nil {
+		return nil, err
+	}
+
+	var reply *ZShieldCoinbaseReply
+	err = json.Unmarshal(*rpcResp.Result, &reply)
+	return reply, err
+}
+
+// Operation status, nil if the node doesn't know it
+func (r *RPCClient) ZGetOperationStatus(opid string) (*ZOperation, error) {
+	return r.getOperation("z_getoperationstatus", opid)
+}
+
+// Like status but also drops finished operation from node memory
+func (r *RPCClient) ZGetOperationResult(opid string) (*ZOperation, error) {
+	return r.getOperation("z_getoperationresult", opid)
+}
+
+func (r *RPCClient) getOperation(method, opid string) (*ZOperation, error) {
+	rpcResp, err := r.doPost(r.Url, method, []interface{}{[]string{opid}})
+	if err != nil {
+		return nil, err
+	}
+
+	var reply []*ZOperation
+	if rpcResp.Result != nil {
+		err = json.Unmarshal(*rpcResp.Result, &reply)
+	}
+	if err != nil || len(reply) == 0 {
+		return nil, err
+	}
+	return reply[0], nil
+}
+
+func (r *RPCClient) GetTransaction(txHash string) (*GetTransactionReply, error) {
+	rpcResp, err := r.doPost(r.Url, "gettransaction", []string{txHash})
+	if err != nil {
+		return nil, err
+	}
+
+	var reply *GetTransactionReply
+	if rpcResp.Result != nil {
+		err = json.Unmarshal(*rpcResp.Result, &reply)
+	}
+	return reply, err
+}
+
+func (r *RPCClient) ListTransactions(count, from int) ([]WalletTransaction, error) {
+	rpcResp, err := r.doPost(r.Url, "listtransactions", []interface{}{"*", count, from})
+	if err != nil {
+		return nil, err
+	}
+
+	var reply []WalletTransaction
+	if rpcResp.Result != nil {
+		err = json.Unmarshal(*rpcResp.Result, &reply)
+	}
 	return reply, err
 }
 
 func (r *RPCClient) doPost(url string, method string, params interface{}) (*JSONRpcResp, error) {
+	return r.doPostWithClient(r.client, url, method, params)
+}
+
+func (r *RPCClient) doPostWithClient(client *http.Client, url string, method string, params interface{}) (*JSONRpcResp, error) {
 	jsonReq := map[string]interface{}{"jsonrpc": "2.0", "method": method, "params": params, "id": 0}
 
 	data, _ := json.Marshal(jsonReq)
@@ -123,7 +395,7 @@ func (r *RPCClient) doPost(url string, method string, params interface{}) (*JSON
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Accept", "application/json")
 
-	resp, err := r.client.Do(req)
+	resp, err := client.Do(req)
 
 	if err != nil {
 		r.markSick()
@@ -141,17 +413,43 @@ func (r *RPCClient) doPost(url string, method string, params interface{}) (*JSON
 	}
 	if rpcResp.Error != nil {
 		r.markSick()
-		return nil, errors.New(rpcResp.Error["message"].(string))
+		code, _ := rpcResp.Error["code"].(float64)
+		message, _ := rpcResp.Error["message"].(string)
+		return nil, &Error{Code: int(code), Message: message}
 	}
 
 	return rpcResp, err
 }
 
+// Probes node with getblockchaininfo, remembering its height and response time
 func (r *RPCClient) Check() bool {
+	start := time.Now()
+	info, err := r.GetBlockchainInfo()
+	if err != nil || info == nil {
+		return !r.Sick()
+	}
+	latency := time.Since(start)
+
+	r.Lock()
+	r.height = info.Blocks
+	r.latency = latency
+	r.Unlock()
 	r.markAlive()
 	return !r.Sick()
 }
 
+func (r *RPCClient) Height() int64 {
+	r.RLock()
+	defer r.RUnlock()
+	return r.height
+}
+
+func (r *RPCClient) Latency() time.Duration {
+	r.RLock()
+	defer r.RUnlock()
+	return r.latency
+}
+
 func (r *RPCClient) Sick() bool {
 	r.RLock()
 	defer r.RUnlock()
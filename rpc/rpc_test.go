@@ -0,0 +1,129 @@
+package rpc
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Fake node answering each method with a canned JSON-RPC result or error
+func newFakeNode(t *testing.T, results map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Method string `json:"method"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+		}
+		result, ok := results[req.Method]
+		if !ok {
+			w.Write([]byte(`{"id":0,"result":null,"error":{"code":-32601,"message":"Method not found"}}`))
+			return
+		}
+		w.Write([]byte(`{"id":0,"result":` + result + `,"error":null}`))
+	}))
+}
+
+func TestSubmitBlock(t *testing.T) {
+	tests := []struct {
+		result       string
+		want         SubmitBlockResult
+		accepted     bool
+		inconclusive bool
+	}{
+		{`null`, SubmitBlockAccepted, true, false},
+		{`"duplicate"`, SubmitBlockDuplicate, false, true},
+		{`"inconclusive"`, SubmitBlockInconclusive, false, true},
+		{`"rejected"`, SubmitBlockRejected, false, false},
+		{`"bad-txnmrklroot"`, SubmitBlockResult("bad-txnmrklroot"), false, false},
+	}
+
+	for _, test := range tests {
+		node := newFakeNode(t, map[string]string{"submitblock": test.result})
+		result, err := NewRPCClient("test", node.URL, "1s").SubmitBlock("00")
+		node.Close()
+
+		if err != nil {
+			t.Errorf("%s: %v", test.result, err)
+			continue
+		}
+		if result != test.want || result.IsAccepted() != test.accepted || result.IsInconclusive() != test.inconclusive {
+			t.Errorf("%s: got %q accepted %v inconclusive %v", test.result, result, result.IsAccepted(), result.IsInconclusive())
+		}
+	}
+}
+
+func TestSubmitBlockError(t *testing.T) {
+	node := newFakeNode(t, map[string]string{})
+	defer node.Close()
+
+	if _, err := NewRPCClient("test", node.URL, "1s").SubmitBlock("00"); err == nil || err.Error() != "Method not found" {
+		t.Errorf("expected node error, got %v", err)
+	}
+}
+
+func TestGetBlockByHash(t *testing.T) {
+	node := newFakeNode(t, map[string]string{"getblock": `{"hash":"00ab","confirmations":1,"height":1000}`})
+	defer node.Close()
+
+	block, err := NewRPCClient("test", node.URL, "1s").GetBlockByHash("00ab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block.Hash != "00ab" || block.Confirmations != 1 || block.Height != 1000 {
+		t.Errorf("unexpected block %+v", block)
+	}
+}
+
+func TestZSendMany(t *testing.T) {
+	node := newFakeNode(t, map[string]string{
+		"z_sendmany":           `"opid-1"`,
+		"z_getoperationstatus": `[{"id":"opid-1","status":"success","result":{"txid":"00ab"}}]`,
+		"z_getoperationresult": `[{"id":"opid-1","status":"failed","error":{"code":-6,"message":"Insufficient funds"}}]`,
+	})
+	defer node.Close()
+	client := NewRPCClient("test", node.URL, "1s")
+
+	opid, err := client.ZSendMany("ANY_TADDR", []ZSendManyAmount{{Address: "zs1", Amount: "0.5"}}, 1, "AllowRevealedSenders")
+	if err != nil || opid != "opid-1" {
+		t.Fatalf("Unexpected z_sendmany reply %q: %v", opid, err)
+	}
+
+	op, err := client.ZGetOperationStatus(opid)
+	if err != nil || op == nil || !op.IsFinished() || op.Result == nil || op.Result.Txid != "00ab" {
+		t.Errorf("Unexpected operation status %+v: %v", op, err)
+	}
+	op, err = client.ZGetOperationResult(opid)
+	if err != nil || op == nil || op.Status != ZOperationFailed || op.Error == nil || op.Error.Message != "Insufficient funds" {
+		t.Errorf("Unexpected operation result %+v: %v", op, err)
+	}
+}
+
+func TestNodeError(t *testing.T) {
+	node := newFakeNode(t, map[string]string{})
+	defer node.Close()
+
+	_, err := NewRPCClient("test", node.URL, "1s").ZSendMany("ANY_TADDR", nil, 1, "")
+	if rpcErr, ok := err.(*Error); !ok || rpcErr.Code != -32601 {
+		t.Errorf("Expected node error, got %v", err)
+	}
+}
+
+func TestZShieldCoinbase(t *testing.T) {
+	node := newFakeNode(t, map[string]string{
+		"listunspent":      `[{"txid":"00ab","vout":0,"address":"tm1","amount":6.25,"confirmations":120,"generated":true}]`,
+		"z_shieldcoinbase": `{"remainingUTXOs":0,"remainingValue":0,"shieldingUTXOs":1,"shieldingValue":6.25,"opid":"opid-1"}`,
+	})
+	defer node.Close()
+	client := NewRPCClient("test", node.URL, "1s")
+
+	unspent, err := client.ListUnspent(100, []string{"tm1"})
+	if err != nil || len(unspent) != 1 || !unspent[0].Generated || unspent[0].Amount != "6.25" {
+		t.Errorf("Unexpected listunspent reply %+v: %v", unspent, err)
+	}
+	reply, err := client.ZShieldCoinbase("tm1", "zs1", 50)
+	if err != nil || reply.Opid != "opid-1" || reply.ShieldingValue != "6.25" {
+		t.Errorf("Unexpected z_shieldcoinbase reply %+v: %v", reply, err)
+	}
+}
@@ -1,10 +1,12 @@
 package storage
 
 import (
+	"encoding/json"
 	"fmt"
 	"math/big"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	redis "gopkg.in/redis.v3"
@@ -20,10 +22,21 @@ type Config struct {
 }
 
 type RedisClient struct {
-	client *redis.Client
-	prefix string
+	// Accessed atomically, keep first for alignment
+	pplnsLimit  int64
+	client      *redis.Client
+	prefix      string
+	pplnsWindow float64
 }
 
+// Who a block pays, kept with candidate and unlocked blocks
+const (
+	SchemePool     = "pool"
+	SchemeSolo     = "solo"
+	SchemePPS      = "pps"
+	SchemeCoinbase = "coinbase"
+)
+
 type BlockData struct {
 	Height         int64    `json:"height"`
 	Timestamp      int64    `json:"timestamp"`
@@ -41,6 +54,7 @@ type BlockData struct {
 	ImmatureReward string   `json:"-"`
 	RewardString   string   `json:"reward"`
 	RoundHeight    int64    `json:"-"`
+	Scheme         string   `json:"scheme"`
 	candidateKey   string
 	immatureKey    string
 }
@@ -58,7 +72,33 @@ func (blockData *BlockData) RoundKey() string {
 }
 
 func (blockData *BlockData) key() string {
-	return join(blockData.Orphan, blockData.Nonce, blockData.serializeHash(), blockData.Timestamp, blockData.Difficulty, blockData.TotalShares, blockData.Reward)
+	return join(blockData.Orphan, blockData.Nonce, blockData.serializeHash(), blockData.Timestamp, blockData.Difficulty, blockData.TotalShares, blockData.Reward, blockData.Scheme)
+}
+
+// Rewards split into the coinbase of a block, outputs are paid by the coinbase
+// transaction itself and credits are left with the pool below the dust threshold
+type CoinbasePayouts struct {
+	TxHash  string           `json:"tx"`
+	Reward  int64            `json:"reward"`
+	Outputs map[string]int64 `json:"outputs"`
+	Credits map[string]int64 `json:"credits"`
+}
+
+type PendingPayment struct {
+	Timestamp int64  `json:"timestamp"`
+	Amount    int64  `json:"amount"`
+	Address   string `json:"login"`
+}
+
+type PayoutRun struct {
+	Id         string
+	TxHash     string
+	Operation  string
+	Shielded   bool
+	FromWallet bool
+	Timestamp  int64
+	Amount     int64
+	Payments   []*PendingPayment
 }
 
 type Miner struct {
@@ -83,6 +123,23 @@ func NewRedisClient(cfg *Config, prefix string) *RedisClient {
 	return &RedisClient{client: client, prefix: prefix}
 }
 
+// Pays blocks to the last shares worth window times network difficulty
+// instead of the shares of the round
+func (redisClient *RedisClient) EnablePPLNS(window float64) {
+	redisClient.pplnsWindow = window
+}
+
+// Caps PPLNS share list, entries past the limit can't be in the window
+func (redisClient *RedisClient) SetPPLNSLimit(entries int64) {
+	atomic.StoreInt64(&redisClient.pplnsLimit, entries)
+}
+
+// Newest PPLNS share entries, only as many as the limit allows
+func (redisClient *RedisClient) pplnsEntries() ([]string, error) {
+	stop := atomic.LoadInt64(&redisClient.pplnsLimit) - 1
+	return redisClient.client.LRange(redisClient.formatKey("shares", "pplns"), 0, stop).Result()
+}
+
 func (redisClient *RedisClient) Client() *redis.Client {
 	return redisClient.client
 }
@@ -119,17 +176,31 @@ func (redisClient *RedisClient) GetBlockFees(height int64, blockType string) (*b
 	return fee, nil
 }
 
-func (redisClient *RedisClient) WriteNodeState(id string, height int64, diff *big.Int) error {
+type UpstreamState struct {
+	Name    string `json:"name"`
+	Height  int64  `json:"height"`
+	Latency int64  `json:"latency"`
+	Sick    bool   `json:"sick"`
+	Behind  bool   `json:"behind"`
+	Active  bool   `json:"active"`
+}
+
+func (redisClient *RedisClient) WriteNodeState(id string, height int64, diff *big.Int, upstreams []UpstreamState) error {
 	tx := redisClient.client.Multi()
 	defer tx.Close()
 
 	now := util.MakeTimestamp() / 1000
+	upstreamsJSON, err := json.Marshal(upstreams)
+	if err != nil {
+		return err
+	}
 
-	_, err := tx.Exec(func() error {
+	_, err = tx.Exec(func() error {
 		tx.HSet(redisClient.formatKey("nodes"), join(id, "name"), id)
 		tx.HSet(redisClient.formatKey("nodes"), join(id, "height"), strconv.FormatInt(height, 10))
 		tx.HSet(redisClient.formatKey("nodes"), join(id, "difficulty"), diff.String())
 		tx.HSet(redisClient.formatKey("nodes"), join(id, "lastBeat"), strconv.FormatInt(now, 10))
+		tx.HSet(redisClient.formatKey("nodes"), join(id, "upstreams"), string(upstreamsJSON))
 		return nil
 	})
 	return err
@@ -143,11 +214,17 @@ func (redisClient *RedisClient) GetNodeStates() ([]map[string]interface{}, error
 	m := make(map[string]map[string]interface{})
 	for key, value := range cmd.Val() {
 		parts := strings.Split(key, ":")
+		var field interface{} = value
+		if parts[1] == "upstreams" {
+			var upstreams []UpstreamState
+			json.Unmarshal([]byte(value), &upstreams)
+			field = upstreams
+		}
 		if val, ok := m[parts[0]]; ok {
-			val[parts[1]] = value
+			val[parts[1]] = field
 		} else {
 			node := make(map[string]interface{})
-			node[parts[1]] = value
+			node[parts[1]] = field
 			m[parts[0]] = node
 		}
 	}
@@ -190,6 +267,19 @@ func (redisClient *RedisClient) WriteShare(login, id string, params []string, di
 	return false, err
 }
 
+// Shares for expired jobs or previous blocks are only counted
+func (redisClient *RedisClient) WriteStaleShare(login string) error {
+	tx := redisClient.client.Multi()
+	defer tx.Close()
+
+	_, err := tx.Exec(func() error {
+		tx.HIncrBy(redisClient.formatKey("stats"), "staleShares", 1)
+		tx.HIncrBy(redisClient.formatKey("miners", login), "staleShares", 1)
+		return nil
+	})
+	return err
+}
+
 func (redisClient *RedisClient) WriteBlock(login, id string, params []string, diff, roundDiff int64, height int64, window time.Duration, feeReward int64, blockHash string) (bool, error) {
 	exist, err := redisClient.checkPoWExist(height, params)
 	if err != nil {
@@ -199,40 +289,315 @@ func (redisClient *RedisClient) WriteBlock(login, id string, params []string, di
 	if exist {
 		return true, nil
 	}
+
+	var pplnsShares map[string]int64
+	var pplnsEntries int
+	if redisClient.pplnsWindow > 0 {
+		entries, err := redisClient.pplnsEntries()
+		if err != nil {
+			return false, err
+		}
+		// Block share is pushed along with the round update below
+		entries = append([]string{join(login, diff)}, entries...)
+		pplnsShares, pplnsEntries = countPPLNSShares(entries, int64(redisClient.pplnsWindow*float64(roundDiff)))
+	}
+
 	tx := redisClient.client.Multi()
 	defer tx.Close()
 
 	ms := util.MakeTimestamp()
 	ts := ms / 1000
+	roundKey := redisClient.formatRound(int64(height), params[0])
+	var roundShares *redis.StringStringMapCmd
 
-	cmds, err := tx.Exec(func() error {
+	_, err = tx.Exec(func() error {
 		redisClient.writeShare(tx, ms, ts, login, id, diff, window)
 		tx.HSet(redisClient.formatKey("stats"), "lastBlockFound", strconv.FormatInt(ts, 10))
 		tx.HDel(redisClient.formatKey("stats"), "roundShares")
 		tx.ZIncrBy(redisClient.formatKey("finders"), 1, login)
 		tx.HIncrBy(redisClient.formatKey("miners", login), "blocksFound", 1)
-		tx.Rename(redisClient.formatKey("shares", "roundCurrent"), redisClient.formatRound(int64(height), params[0]))
-		tx.HGetAllMap(redisClient.formatRound(height, params[0]))
+		if pplnsShares != nil {
+			tx.Del(redisClient.formatKey("shares", "roundCurrent"))
+			for login, n := range pplnsShares {
+				tx.HIncrBy(roundKey, login, n)
+			}
+			// Keep headroom for difficulty growth until the next block
+			tx.LTrim(redisClient.formatKey("shares", "pplns"), 0, int64(2*pplnsEntries-1))
+		} else {
+			tx.Rename(redisClient.formatKey("shares", "roundCurrent"), roundKey)
+		}
+		roundShares = tx.HGetAllMap(roundKey)
 		return nil
 	})
 	if err != nil {
 		return false, err
 	} else {
-		sharesMap, _ := cmds[10].(*redis.StringStringMapCmd).Result()
+		sharesMap, _ := roundShares.Result()
 		totalShares := int64(0)
 		for _, v := range sharesMap {
 			n, _ := strconv.ParseInt(v, 10, 64)
 			totalShares += n
 		}
 		paramsJoined := strings.Join(params, ":")
-		s := join(blockHash, paramsJoined, ts, roundDiff, totalShares, feeReward)
+		s := join(blockHash, paramsJoined, ts, roundDiff, totalShares, feeReward, SchemePool)
 		cmd := redisClient.client.ZAdd(redisClient.formatKey("blocks", "candidates"), redis.Z{Score: float64(height), Member: s})
 		return false, cmd.Err()
 	}
 }
 
+// Miners of a block paying them in its coinbase are known when the job is made,
+// the block share only moves the PPLNS window on
+func (redisClient *RedisClient) WriteCoinbaseBlock(login, id string, params []string, diff, roundDiff int64, height int64, window time.Duration, feeReward int64, blockHash string, payouts *CoinbasePayouts) (bool, error) {
+	exist, err := redisClient.checkPoWExist(height, params)
+	if err != nil {
+		return false, err
+	}
+
+	if exist {
+		return true, nil
+	}
+
+	entries, err := redisClient.pplnsEntries()
+	if err != nil {
+		return false, err
+	}
+	entries = append([]string{join(login, diff)}, entries...)
+	pplnsShares, pplnsEntries := countPPLNSShares(entries, int64(redisClient.pplnsWindow*float64(roundDiff)))
+
+	payoutsJSON, err := json.Marshal(payouts)
+	if err != nil {
+		return false, err
+	}
+
+	tx := redisClient.client.Multi()
+	defer tx.Close()
+
+	ms := util.MakeTimestamp()
+	ts := ms / 1000
+
+	_, err = tx.Exec(func() error {
+		redisClient.writeShare(tx, ms, ts, login, id, diff, window)
+		tx.HSet(redisClient.formatKey("stats"), "lastBlockFound", strconv.FormatInt(ts, 10))
+		tx.HDel(redisClient.formatKey("stats"), "roundShares")
+		tx.ZIncrBy(redisClient.formatKey("finders"), 1, login)
+		tx.HIncrBy(redisClient.formatKey("miners", login), "blocksFound", 1)
+		tx.Del(redisClient.formatKey("shares", "roundCurrent"))
+		tx.LTrim(redisClient.formatKey("shares", "pplns"), 0, int64(2*pplnsEntries-1))
+		tx.Set(redisClient.formatKey("coinbase", blockHash), string(payoutsJSON), 0)
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	totalShares := int64(0)
+	for _, n := range pplnsShares {
+		totalShares += n
+	}
+	s := join(blockHash, strings.Join(params, ":"), ts, roundDiff, totalShares, feeReward, SchemeCoinbase)
+	cmd := redisClient.client.ZAdd(redisClient.formatKey("blocks", "candidates"), redis.Z{Score: float64(height), Member: s})
+	return false, cmd.Err()
+}
+
+func (redisClient *RedisClient) GetCoinbasePayouts(blockHash string) (*CoinbasePayouts, error) {
+	value, err := redisClient.client.Get(redisClient.formatKey("coinbase", blockHash)).Result()
+	if err != nil {
+		return nil, err
+	}
+	var payouts CoinbasePayouts
+	err = json.Unmarshal([]byte(value), &payouts)
+	return &payouts, err
+}
+
+// Current PPLNS window of window difficulty by login
+func (redisClient *RedisClient) GetPPLNSShares(window int64) (map[string]int64, error) {
+	entries, err := redisClient.pplnsEntries()
+	if err != nil {
+		return nil, err
+	}
+	shares, _ := countPPLNSShares(entries, window)
+	return shares, nil
+}
+
+// Solo shares count for hashrate stats only
+func (redisClient *RedisClient) WriteSoloShare(login, id string, params []string, diff int64, height int64, window time.Duration) (bool, error) {
+	exist, err := redisClient.checkPoWExist(height, params)
+	if err != nil {
+		return false, err
+	}
+
+	if exist {
+		return true, nil
+	}
+	tx := redisClient.client.Multi()
+	defer tx.Close()
+
+	ms := util.MakeTimestamp()
+	ts := ms / 1000
+
+	_, err = tx.Exec(func() error {
+		redisClient.writeHashrate(tx, ms, ts, login, id, diff, window)
+		return nil
+	})
+	return false, err
+}
+
+// Solo block gets a round of its own holding only the finder, the shared round goes on
+func (redisClient *RedisClient) WriteSoloBlock(login, id string, params []string, diff, roundDiff int64, height int64, window time.Duration, feeReward int64, blockHash string) (bool, error) {
+	exist, err := redisClient.checkPoWExist(height, params)
+	if err != nil {
+		return false, err
+	}
+
+	if exist {
+		return true, nil
+	}
+	tx := redisClient.client.Multi()
+	defer tx.Close()
+
+	ms := util.MakeTimestamp()
+	ts := ms / 1000
+
+	_, err = tx.Exec(func() error {
+		redisClient.writeHashrate(tx, ms, ts, login, id, diff, window)
+		tx.HSet(redisClient.formatKey("stats"), "lastBlockFound", strconv.FormatInt(ts, 10))
+		tx.ZIncrBy(redisClient.formatKey("finders"), 1, login)
+		tx.HIncrBy(redisClient.formatKey("miners", login), "blocksFound", 1)
+		tx.HIncrBy(redisClient.formatRound(height, params[0]), login, diff)
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	s := join(blockHash, strings.Join(params, ":"), ts, roundDiff, diff, feeReward, SchemeSolo)
+	cmd := redisClient.client.ZAdd(redisClient.formatKey("blocks", "candidates"), redis.Z{Score: float64(height), Member: s})
+	return false, cmd.Err()
+}
+
+// PPS share is paid at once from the pool reserve
+func (redisClient *RedisClient) WritePPSShare(login, id string, params []string, diff int64, height int64, window time.Duration, credit int64) (bool, error) {
+	exist, err := redisClient.checkPoWExist(height, params)
+	if err != nil {
+		return false, err
+	}
+
+	if exist {
+		return true, nil
+	}
+	tx := redisClient.client.Multi()
+	defer tx.Close()
+
+	ms := util.MakeTimestamp()
+	ts := ms / 1000
+
+	_, err = tx.Exec(func() error {
+		redisClient.writeHashrate(tx, ms, ts, login, id, diff, window)
+		redisClient.writePPSCredit(tx, login, credit)
+		return nil
+	})
+	return false, err
+}
+
+// PPS block has no round, its reward goes to the pool reserve once matured
+func (redisClient *RedisClient) WritePPSBlock(login, id string, params []string, diff, roundDiff int64, height int64, window time.Duration, feeReward int64, blockHash string, credit int64) (bool, error) {
+	exist, err := redisClient.checkPoWExist(height, params)
+	if err != nil {
+		return false, err
+	}
+
+	if exist {
+		return true, nil
+	}
+	tx := redisClient.client.Multi()
+	defer tx.Close()
+
+	ms := util.MakeTimestamp()
+	ts := ms / 1000
+
+	_, err = tx.Exec(func() error {
+		redisClient.writeHashrate(tx, ms, ts, login, id, diff, window)
+		redisClient.writePPSCredit(tx, login, credit)
+		tx.HSet(redisClient.formatKey("stats"), "lastBlockFound", strconv.FormatInt(ts, 10))
+		tx.ZIncrBy(redisClient.formatKey("finders"), 1, login)
+		tx.HIncrBy(redisClient.formatKey("miners", login), "blocksFound", 1)
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	s := join(blockHash, strings.Join(params, ":"), ts, roundDiff, 0, feeReward, SchemePPS)
+	cmd := redisClient.client.ZAdd(redisClient.formatKey("blocks", "candidates"), redis.Z{Score: float64(height), Member: s})
+	return false, cmd.Err()
+}
+
+func (redisClient *RedisClient) writePPSCredit(tx *redis.Multi, login string, credit int64) {
+	tx.HIncrBy(redisClient.formatKey("miners", login), "balance", credit)
+	tx.HIncrBy(redisClient.formatKey("finances"), "balance", credit)
+	tx.HIncrBy(redisClient.formatKey("pps"), "reserve", -credit)
+	tx.HIncrBy(redisClient.formatKey("pps"), "credited", credit)
+}
+
+// Pool reserve paying PPS shares, negative while the pool is unlucky
+func (redisClient *RedisClient) GetPPSReserve() (int64, error) {
+	reserve, err := redisClient.client.HGet(redisClient.formatKey("pps"), "reserve").Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return reserve, err
+}
+
+// Sums the newest "login:diff" entries per login until window is filled,
+// returns them with the number of entries used
+func countPPLNSShares(entries []string, window int64) (map[string]int64, int) {
+	shares := make(map[string]int64)
+	var total int64
+	for i, entry := range entries {
+		fields := strings.Split(entry, ":")
+		if len(fields) != 2 {
+			continue
+		}
+		n, _ := strconv.ParseInt(fields[1], 10, 64)
+		if total+n >= window {
+			if window > total {
+				shares[fields[0]] += window - total
+			}
+			return shares, i + 1
+		}
+		shares[fields[0]] += n
+		total += n
+	}
+	return shares, len(entries)
+}
+
+// Blocks refused by all nodes, kept apart from candidates along with the reason
+func (redisClient *RedisClient) WriteRejectedBlock(login string, height int64, blockHash, reason string) error {
+	tx := redisClient.client.Multi()
+	defer tx.Close()
+
+	ts := util.MakeTimestamp() / 1000
+
+	_, err := tx.Exec(func() error {
+		tx.ZAdd(redisClient.formatKey("blocks", "rejected"), redis.Z{Score: float64(height), Member: join(blockHash, login, ts, reason)})
+		tx.HIncrBy(redisClient.formatKey("stats"), "rejectedBlocks", 1)
+		return nil
+	})
+	return err
+}
+
 func (redisClient *RedisClient) writeShare(tx *redis.Multi, ms, ts int64, login, id string, diff int64, expire time.Duration) {
 	tx.HIncrBy(redisClient.formatKey("shares", "roundCurrent"), login, diff)
+	if redisClient.pplnsWindow > 0 {
+		tx.LPush(redisClient.formatKey("shares", "pplns"), join(login, diff))
+		if limit := atomic.LoadInt64(&redisClient.pplnsLimit); limit > 0 {
+			tx.LTrim(redisClient.formatKey("shares", "pplns"), 0, limit-1)
+		}
+	}
+	redisClient.writeHashrate(tx, ms, ts, login, id, diff, expire)
+}
+
+func (redisClient *RedisClient) writeHashrate(tx *redis.Multi, ms, ts int64, login, id string, diff int64, expire time.Duration) {
 	tx.ZAdd(redisClient.formatKey("hashrate"), redis.Z{Score: float64(ts), Member: join(diff, login, id, ms, diff*35)})
 	tx.ZAdd(redisClient.formatKey("hashrate", login), redis.Z{Score: float64(ts), Member: join(diff, id, ms, diff*35)})
 	tx.Expire(redisClient.formatKey("hashrate", login), expire) // Will delete hashrates for miners that gone
@@ -321,6 +686,387 @@ func (redisClient *RedisClient) GetBalance(login string) (int64, error) {
 	return cmd.Int64()
 }
 
+// Payout threshold miner has set for itself, 0 if none
+func (redisClient *RedisClient) GetMinPayout(login string) (int64, error) {
+	cmd := redisClient.client.HGet(redisClient.formatKey("miners", login), "minPayout")
+	if cmd.Err() == redis.Nil {
+		return 0, nil
+	} else if cmd.Err() != nil {
+		return 0, cmd.Err()
+	}
+	return cmd.Int64()
+}
+
+func (redisClient *RedisClient) SetMinPayout(login string, amount int64) error {
+	return redisClient.client.HSet(redisClient.formatKey("miners", login), "minPayout", strconv.FormatInt(amount, 10)).Err()
+}
+
+func (redisClient *RedisClient) GetPayees() ([]string, error) {
+	payees := make(map[string]struct{})
+	var result []string
+	var c int64
+
+	for {
+		var keys []string
+		var err error
+		c, keys, err = redisClient.client.Scan(c, redisClient.formatKey("miners", "*"), 100).Result()
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range keys {
+			login := strings.Split(row, ":")[2]
+			payees[login] = struct{}{}
+		}
+		if c == 0 {
+			break
+		}
+	}
+	for login := range payees {
+		result = append(result, login)
+	}
+	return result, nil
+}
+
+func (redisClient *RedisClient) LockPayouts(login string, amount int64) error {
+	key := redisClient.formatKey("payments", "lock")
+	result := redisClient.client.SetNX(key, join(login, amount), 0).Val()
+	if !result {
+		return fmt.Errorf("Unable to acquire lock '%s'", key)
+	}
+	return nil
+}
+
+func (redisClient *RedisClient) UnlockPayouts() error {
+	key := redisClient.formatKey("payments", "lock")
+	_, err := redisClient.client.Del(key).Result()
+	return err
+}
+
+func (redisClient *RedisClient) IsPayoutsLocked() (bool, error) {
+	_, err := redisClient.client.Get(redisClient.formatKey("payments", "lock")).Result()
+	if err == redis.Nil {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	} else {
+		return true, nil
+	}
+}
+
+func (redisClient *RedisClient) GetPendingPayments() []*PendingPayment {
+	raw := redisClient.client.ZRevRangeWithScores(redisClient.formatKey("payments", "pending"), 0, -1)
+	var result []*PendingPayment
+	for _, v := range raw.Val() {
+		// timestamp -> "login:amount"
+		payment := PendingPayment{}
+		payment.Timestamp = int64(v.Score)
+		fields := strings.Split(v.Member.(string), ":")
+		payment.Address = fields[0]
+		payment.Amount, _ = strconv.ParseInt(fields[1], 10, 64)
+		result = append(result, &payment)
+	}
+	return result
+}
+
+// Deduct miner's balance for payment
+func (redisClient *RedisClient) UpdateBalance(login string, amount int64) error {
+	tx := redisClient.client.Multi()
+	defer tx.Close()
+
+	ts := util.MakeTimestamp() / 1000
+
+	_, err := tx.Exec(func() error {
+		redisClient.updateBalance(tx, ts, login, amount)
+		return nil
+	})
+	return err
+}
+
+func (redisClient *RedisClient) RollbackBalance(login string, amount int64) error {
+	tx := redisClient.client.Multi()
+	defer tx.Close()
+
+	_, err := tx.Exec(func() error {
+		redisClient.rollbackBalance(tx, login, amount)
+		return nil
+	})
+	return err
+}
+
+func (redisClient *RedisClient) WritePayment(login, txHash string, amount int64) error {
+	tx := redisClient.client.Multi()
+	defer tx.Close()
+
+	ts := util.MakeTimestamp() / 1000
+
+	_, err := tx.Exec(func() error {
+		redisClient.writePayment(tx, ts, login, txHash, amount)
+		tx.Del(redisClient.formatKey("payments", "lock"))
+		return nil
+	})
+	return err
+}
+
+// Journal a payout run and debit all balances in one transaction,
+// so a crash can never leave a run half debited
+func (redisClient *RedisClient) WritePayoutRun(runId string, amounts map[string]int64) error {
+	return redisClient.writePayoutRun(runId, amounts, false, false)
+}
+
+// Shielded run is sent by z_sendmany, wallet comment can't identify it. Run paid
+// from funds shielded by wallet manager takes them off the released amount.
+func (redisClient *RedisClient) WriteShieldedPayoutRun(runId string, amounts map[string]int64, fromWallet bool) error {
+	return redisClient.writePayoutRun(runId, amounts, true, fromWallet)
+}
+
+func (redisClient *RedisClient) writePayoutRun(runId string, amounts map[string]int64, shielded, fromWallet bool) error {
+	tx := redisClient.client.Multi()
+	defer tx.Close()
+
+	ts := util.MakeTimestamp() / 1000
+
+	_, err := tx.Exec(func() error {
+		total := int64(0)
+		for login, amount := range amounts {
+			total += amount
+			redisClient.updateBalance(tx, ts, login, amount)
+		}
+		tx.HMSetMap(redisClient.formatKey("payments", "run"), map[string]string{
+			"id":        runId,
+			"timestamp": strconv.FormatInt(ts, 10),
+			"amount":    strconv.FormatInt(total, 10),
+			"shielded":  strconv.FormatBool(shielded),
+			"wallet":    strconv.FormatBool(fromWallet),
+		})
+		if fromWallet {
+			tx.HIncrBy(redisClient.formatKey("wallet"), "available", (total * -1))
+		}
+		return nil
+	})
+	return err
+}
+
+func (redisClient *RedisClient) SetPayoutRunTx(txHash string) error {
+	return redisClient.client.HSet(redisClient.formatKey("payments", "run"), "tx", txHash).Err()
+}
+
+func (redisClient *RedisClient) SetPayoutRunOperation(opid string) error {
+	return redisClient.client.HSet(redisClient.formatKey("payments", "run"), "opid", opid).Err()
+}
+
+// Returns journaled payout run or nil if there is no unresolved run
+func (redisClient *RedisClient) GetPayoutRun() (*PayoutRun, error) {
+	cmd := redisClient.client.HGetAllMap(redisClient.formatKey("payments", "run"))
+	if cmd.Err() != nil {
+		return nil, cmd.Err()
+	}
+	fields := cmd.Val()
+	if len(fields) == 0 {
+		return nil, nil
+	}
+
+	run := PayoutRun{Id: fields["id"], TxHash: fields["tx"], Operation: fields["opid"]}
+	run.Shielded, _ = strconv.ParseBool(fields["shielded"])
+	run.FromWallet, _ = strconv.ParseBool(fields["wallet"])
+	run.Timestamp, _ = strconv.ParseInt(fields["timestamp"], 10, 64)
+	run.Amount, _ = strconv.ParseInt(fields["amount"], 10, 64)
+	run.Payments = redisClient.GetPendingPayments()
+	return &run, nil
+}
+
+func (redisClient *RedisClient) FinalizePayoutRun(run *PayoutRun, txHash string) error {
+	tx := redisClient.client.Multi()
+	defer tx.Close()
+
+	ts := util.MakeTimestamp() / 1000
+
+	_, err := tx.Exec(func() error {
+		for _, payment := range run.Payments {
+			redisClient.writePayment(tx, ts, payment.Address, txHash, payment.Amount)
+		}
+		tx.Del(redisClient.formatKey("payments", "run"))
+		tx.Del(redisClient.formatKey("payments", "lock"))
+		return nil
+	})
+	return err
+}
+
+func (redisClient *RedisClient) RollbackPayoutRun(run *PayoutRun) error {
+	tx := redisClient.client.Multi()
+	defer tx.Close()
+
+	_, err := tx.Exec(func() error {
+		for _, payment := range run.Payments {
+			redisClient.rollbackBalance(tx, payment.Address, payment.Amount)
+		}
+		if run.FromWallet {
+			tx.HIncrBy(redisClient.formatKey("wallet"), "available", run.Amount)
+		}
+		tx.Del(redisClient.formatKey("payments", "run"))
+		tx.Del(redisClient.formatKey("payments", "lock"))
+		return nil
+	})
+	return err
+}
+
+// Credits back run the wallet refused to send, keeping the reason in history
+func (redisClient *RedisClient) WriteFailedPayoutRun(run *PayoutRun, reason string) error {
+	tx := redisClient.client.Multi()
+	defer tx.Close()
+
+	ts := util.MakeTimestamp() / 1000
+
+	_, err := tx.Exec(func() error {
+		for _, payment := range run.Payments {
+			redisClient.rollbackBalance(tx, payment.Address, payment.Amount)
+			tx.ZAdd(redisClient.formatKey("payments", "failed"), redis.Z{Score: float64(ts), Member: join(run.Id, payment.Address, payment.Amount, reason)})
+		}
+		if run.FromWallet {
+			tx.HIncrBy(redisClient.formatKey("wallet"), "available", run.Amount)
+		}
+		tx.Del(redisClient.formatKey("payments", "run"))
+		tx.Del(redisClient.formatKey("payments", "lock"))
+		return nil
+	})
+	return err
+}
+
+type ShieldingOperation struct {
+	Opid      string
+	Amount    int64
+	Timestamp int64
+}
+
+type ShieldedTx struct {
+	TxHash    string
+	Amount    int64
+	Timestamp int64
+}
+
+// Coinbase shielding operation in progress or nil
+func (redisClient *RedisClient) GetShieldingOperation() (*ShieldingOperation, error) {
+	cmd := redisClient.client.HGetAllMap(redisClient.formatKey("wallet", "shielding"))
+	if cmd.Err() != nil {
+		return nil, cmd.Err()
+	}
+	fields := cmd.Val()
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	op := ShieldingOperation{Opid: fields["opid"]}
+	op.Amount, _ = strconv.ParseInt(fields["amount"], 10, 64)
+	op.Timestamp, _ = strconv.ParseInt(fields["timestamp"], 10, 64)
+	return &op, nil
+}
+
+func (redisClient *RedisClient) WriteShieldingOperation(opid string, amount int64) error {
+	ts := util.MakeTimestamp() / 1000
+	return redisClient.client.HMSetMap(redisClient.formatKey("wallet", "shielding"), map[string]string{
+		"opid":      opid,
+		"amount":    strconv.FormatInt(amount, 10),
+		"timestamp": strconv.FormatInt(ts, 10),
+	}).Err()
+}
+
+// Finishes shielding operation, its tx waits for confirmations unless it failed
+func (redisClient *RedisClient) WriteShieldingResult(op *ShieldingOperation, txHash, reason string) error {
+	tx := redisClient.client.Multi()
+	defer tx.Close()
+
+	ts := util.MakeTimestamp() / 1000
+
+	_, err := tx.Exec(func() error {
+		tx.Del(redisClient.formatKey("wallet", "shielding"))
+		if len(txHash) > 0 {
+			tx.ZAdd(redisClient.formatKey("wallet", "pending"), redis.Z{Score: float64(ts), Member: join(txHash, op.Amount)})
+			tx.HIncrBy(redisClient.formatKey("wallet"), "pending", op.Amount)
+		}
+		tx.ZAdd(redisClient.formatKey("wallet", "history"), redis.Z{Score: float64(ts), Member: join(op.Opid, txHash, op.Amount, reason)})
+		return nil
+	})
+	return err
+}
+
+func (redisClient *RedisClient) GetPendingShieldedTxs() ([]*ShieldedTx, error) {
+	raw := redisClient.client.ZRangeWithScores(redisClient.formatKey("wallet", "pending"), 0, -1)
+	if raw.Err() != nil {
+		return nil, raw.Err()
+	}
+	var result []*ShieldedTx
+	for _, v := range raw.Val() {
+		fields := strings.Split(v.Member.(string), ":")
+		if len(fields) != 2 {
+			continue
+		}
+		amount, _ := strconv.ParseInt(fields[1], 10, 64)
+		result = append(result, &ShieldedTx{TxHash: fields[0], Amount: amount, Timestamp: int64(v.Score)})
+	}
+	return result, nil
+}
+
+// Confirmed shielded funds less tx fee become available to payouts
+func (redisClient *RedisClient) ReleaseShieldedTx(shieldedTx *ShieldedTx, fee int64) error {
+	tx := redisClient.client.Multi()
+	defer tx.Close()
+
+	_, err := tx.Exec(func() error {
+		tx.ZRem(redisClient.formatKey("wallet", "pending"), join(shieldedTx.TxHash, shieldedTx.Amount))
+		tx.HIncrBy(redisClient.formatKey("wallet"), "pending", (shieldedTx.Amount * -1))
+		tx.HIncrBy(redisClient.formatKey("wallet"), "available", shieldedTx.Amount-fee)
+		tx.HIncrBy(redisClient.formatKey("wallet"), "shielded", shieldedTx.Amount-fee)
+		return nil
+	})
+	return err
+}
+
+// Fee of payout tx spent from shielded funds
+func (redisClient *RedisClient) WriteWalletFee(fee int64) error {
+	return redisClient.client.HIncrBy(redisClient.formatKey("wallet"), "available", (fee * -1)).Err()
+}
+
+// Shielded funds payouts may spend
+func (redisClient *RedisClient) GetWalletAvailable() (int64, error) {
+	available, err := redisClient.client.HGet(redisClient.formatKey("wallet"), "available").Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return available, err
+}
+
+func (redisClient *RedisClient) updateBalance(tx *redis.Multi, ts int64, login string, amount int64) {
+	tx.HIncrBy(redisClient.formatKey("miners", login), "balance", (amount * -1))
+	tx.HIncrBy(redisClient.formatKey("miners", login), "pending", amount)
+	tx.HIncrBy(redisClient.formatKey("finances"), "balance", (amount * -1))
+	tx.HIncrBy(redisClient.formatKey("finances"), "pending", amount)
+	tx.ZAdd(redisClient.formatKey("payments", "pending"), redis.Z{Score: float64(ts), Member: join(login, amount)})
+}
+
+func (redisClient *RedisClient) rollbackBalance(tx *redis.Multi, login string, amount int64) {
+	tx.HIncrBy(redisClient.formatKey("miners", login), "balance", amount)
+	tx.HIncrBy(redisClient.formatKey("miners", login), "pending", (amount * -1))
+	tx.HIncrBy(redisClient.formatKey("finances"), "balance", amount)
+	tx.HIncrBy(redisClient.formatKey("finances"), "pending", (amount * -1))
+	tx.ZRem(redisClient.formatKey("payments", "pending"), join(login, amount))
+}
+
+func (redisClient *RedisClient) writePayment(tx *redis.Multi, ts int64, login, txHash string, amount int64) {
+	tx.HIncrBy(redisClient.formatKey("miners", login), "pending", (amount * -1))
+	tx.HIncrBy(redisClient.formatKey("miners", login), "paid", amount)
+	tx.HIncrBy(redisClient.formatKey("finances"), "pending", (amount * -1))
+	tx.HIncrBy(redisClient.formatKey("finances"), "paid", amount)
+	tx.ZAdd(redisClient.formatKey("payments", "all"), redis.Z{Score: float64(ts), Member: join(txHash, login, amount)})
+	tx.ZAdd(redisClient.formatKey("payments", login), redis.Z{Score: float64(ts), Member: join(txHash, amount)})
+	tx.ZRem(redisClient.formatKey("payments", "pending"), join(login, amount))
+}
+
+// Coinbase outputs never go through balance and pending
+func (redisClient *RedisClient) writeCoinbasePayment(tx *redis.Multi, ts int64, login, txHash string, amount int64) {
+	tx.HIncrBy(redisClient.formatKey("miners", login), "paid", amount)
+	tx.HIncrBy(redisClient.formatKey("finances"), "paid", amount)
+	tx.ZAdd(redisClient.formatKey("payments", "all"), redis.Z{Score: float64(ts), Member: join(txHash, login, amount)})
+	tx.ZAdd(redisClient.formatKey("payments", login), redis.Z{Score: float64(ts), Member: join(txHash, amount)})
+}
+
 func (redisClient *RedisClient) WriteImmatureBlock(block *BlockData, roundRewards map[string]int64) error {
 	tx := redisClient.client.Multi()
 	defer tx.Close()
@@ -349,6 +1095,14 @@ func (redisClient *RedisClient) WriteMaturedBlock(block *BlockData, roundRewards
 	}
 	defer tx.Close()
 
+	var payouts *CoinbasePayouts
+	if block.Scheme == SchemeCoinbase {
+		payouts, err = redisClient.GetCoinbasePayouts(block.Hash)
+		if err != nil {
+			return err
+		}
+	}
+
 	ts := util.MakeTimestamp() / 1000
 	value := join(block.Hash, ts, block.Reward)
 
@@ -373,8 +1127,19 @@ func (redisClient *RedisClient) WriteMaturedBlock(block *BlockData, roundRewards
 			tx.HSetNX(redisClient.formatKey("credits", block.Height, block.Hash), login, strconv.FormatInt(amount, 10))
 		}
 		tx.Del(creditKey)
+		if payouts != nil {
+			for login, amount := range payouts.Outputs {
+				redisClient.writeCoinbasePayment(tx, ts, login, payouts.TxHash, amount)
+			}
+			tx.Del(redisClient.formatKey("coinbase", block.Hash))
+		}
 		tx.HIncrBy(redisClient.formatKey("finances"), "balance", total)
 		tx.HIncrBy(redisClient.formatKey("finances"), "immature", (totalImmature * -1))
+		if block.Scheme == SchemePPS {
+			tx.HIncrBy(redisClient.formatKey("pps"), "reserve", block.Reward.Int64())
+			tx.HIncrBy(redisClient.formatKey("pps"), "blockRewards", block.Reward.Int64())
+			tx.ZAdd(redisClient.formatKey("pps", "ledger"), redis.Z{Score: float64(block.Height), Member: value})
+		}
 		tx.HSet(redisClient.formatKey("finances"), "lastCreditHeight", strconv.FormatInt(block.Height, 10))
 		tx.HSet(redisClient.formatKey("finances"), "lastCreditHash", block.Hash)
 		tx.HIncrBy(redisClient.formatKey("finances"), "totalMined", block.Reward.Int64())
@@ -404,6 +1169,7 @@ func (redisClient *RedisClient) WriteOrphan(block *BlockData) error {
 			tx.HIncrBy(redisClient.formatKey("miners", login), "immature", (amount * -1))
 		}
 		tx.Del(creditKey)
+		tx.Del(redisClient.formatKey("coinbase", block.Hash))
 		tx.HIncrBy(redisClient.formatKey("finances"), "immature", (totalImmature * -1))
 		return nil
 	})
@@ -438,6 +1204,33 @@ func (redisClient *RedisClient) writeMaturedBlock(tx *redis.Multi, block *BlockD
 	tx.ZAdd(redisClient.formatKey("blocks", "matured"), redis.Z{Score: float64(block.Height), Member: block.key()})
 }
 
+// Logins and IP addresses refused by the policy server
+func (redisClient *RedisClient) GetBlacklist() ([]string, error) {
+	cmd := redisClient.client.SMembers(redisClient.formatKey("blacklist"))
+	if cmd.Err() != nil {
+		return []string{}, cmd.Err()
+	}
+	return cmd.Val(), nil
+}
+
+// IP addresses never banned by the policy server
+func (redisClient *RedisClient) GetWhitelist() ([]string, error) {
+	cmd := redisClient.client.SMembers(redisClient.formatKey("whitelist"))
+	if cmd.Err() != nil {
+		return []string{}, cmd.Err()
+	}
+	return cmd.Val(), nil
+}
+
+// Bans of the policy server, they expire by themselves
+func (redisClient *RedisClient) WriteBan(ip string, timeout time.Duration) error {
+	return redisClient.client.Set(redisClient.formatKey("bans", ip), strconv.FormatInt(util.MakeTimestamp(), 10), timeout).Err()
+}
+
+func (redisClient *RedisClient) IsBanned(ip string) (bool, error) {
+	return redisClient.client.Exists(redisClient.formatKey("bans", ip)).Result()
+}
+
 func (redisClient *RedisClient) IsMinerExists(login string) (bool, error) {
 	return redisClient.client.Exists(redisClient.formatKey("miners", login)).Result()
 }
@@ -684,7 +1477,7 @@ func (redisClient *RedisClient) CollectLuckStats(windows []int) (map[string]inte
 func convertCandidateResults(raw *redis.ZSliceCmd) []*BlockData {
 	var result []*BlockData
 	for _, v := range raw.Val() {
-		// "blockHex:nonce:powHash:mixDigest:timestamp:diff:totalShares:extraReward"
+		// "blockHash:params(5):timestamp:diff:totalShares:extraReward:scheme"
 		block := BlockData{}
 		block.Height = int64(v.Score)
 		block.RoundHeight = block.Height
@@ -697,6 +1490,10 @@ func convertCandidateResults(raw *redis.ZSliceCmd) []*BlockData {
 		block.Difficulty, _ = strconv.ParseInt(fields[7], 10, 64)
 		block.TotalShares, _ = strconv.ParseInt(fields[8], 10, 64)
 		block.ExtraReward, _ = new(big.Int).SetString(fields[9], 10)
+		block.Scheme = SchemePool
+		if len(fields) > 10 {
+			block.Scheme = fields[10]
+		}
 		block.candidateKey = v.Member.(string)
 		result = append(result, &block)
 	}
@@ -707,7 +1504,7 @@ func convertBlockResults(rows ...*redis.ZSliceCmd) []*BlockData {
 	var result []*BlockData
 	for _, row := range rows {
 		for _, v := range row.Val() {
-			// "orphan:nonce:blockHash:timestamp:diff:totalShares:rewardInZatoshi"
+			// "orphan:nonce:blockHash:timestamp:diff:totalShares:rewardInZatoshi:scheme"
 			block := BlockData{}
 			block.Height = int64(v.Score)
 			block.RoundHeight = block.Height
@@ -720,6 +1517,10 @@ func convertBlockResults(rows ...*redis.ZSliceCmd) []*BlockData {
 			block.TotalShares, _ = strconv.ParseInt(fields[5], 10, 64)
 			block.RewardString = fields[6]
 			block.ImmatureReward = fields[6]
+			block.Scheme = SchemePool
+			if len(fields) > 7 {
+				block.Scheme = fields[7]
+			}
 			block.immatureKey = v.Member.(string)
 			result = append(result, &block)
 		}
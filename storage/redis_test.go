@@ -1,25 +1,35 @@
 package storage
 
-// import (
-// 	"os"
-// 	"reflect"
-// 	"strconv"
-// 	"testing"
-
-// 	"gopkg.in/redis.v3"
-// )
-
-// var r *RedisClient
-
-// const prefix = "test"
-
-// func TestMain(m *testing.M) {
-// 	r = NewRedisClient(&Config{Endpoint: "127.0.0.1:6379"}, prefix)
-// 	reset()
-// 	c := m.Run()
-// 	reset()
-// 	os.Exit(c)
-// }
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"gopkg.in/redis.v3"
+)
+
+var r *RedisClient
+
+const prefix = "test"
+
+// Suite needs a scratch redis, REDIS_ENDPOINT overrides the local one
+func TestMain(m *testing.M) {
+	endpoint := os.Getenv("REDIS_ENDPOINT")
+	if len(endpoint) == 0 {
+		endpoint = "127.0.0.1:6379"
+	}
+	r = NewRedisClient(&Config{Endpoint: endpoint}, prefix)
+	if _, err := r.Check(); err != nil {
+		fmt.Printf("Skipping storage tests, redis at %s is unreachable: %v\n", endpoint, err)
+		os.Exit(0)
+	}
+	reset()
+	c := m.Run()
+	reset()
+	os.Exit(c)
+}
 
 // func TestWriteShareCheckExist(t *testing.T) {
 // 	reset()
@@ -46,248 +56,356 @@ package storage
 // 	}
 // }
 
-// func TestGetPayees(t *testing.T) {
-// 	reset()
-
-// 	n := 256
-// 	for i := 0; i < n; i++ {
-// 		r.client.HSet(r.formatKey("miners", strconv.Itoa(i)), "balance", strconv.Itoa(i))
-// 	}
-
-// 	var payees []string
-// 	payees, _ = r.GetPayees()
-// 	if len(payees) != n {
-// 		t.Error("Must return all payees")
-// 	}
-// 	m := make(map[string]struct{})
-// 	for _, v := range payees {
-// 		m[v] = struct{}{}
-// 	}
-// 	if len(m) != n {
-// 		t.Error("Must be unique list")
-// 	}
-// }
-
-// func TestGetBalance(t *testing.T) {
-// 	reset()
-
-// 	r.client.HSet(r.formatKey("miners:x"), "balance", "750")
-
-// 	v, _ := r.GetBalance("x")
-// 	if v != 750 {
-// 		t.Error("Must return balance")
-// 	}
-
-// 	v, err := r.GetBalance("z")
-// 	if v != 0 {
-// 		t.Error("Must return 0 if account does not exist")
-// 	}
-// 	if err != nil {
-// 		t.Error("Must not return error if account does not exist")
-// 	}
-// }
-
-// func TestLockPayouts(t *testing.T) {
-// 	reset()
-
-// 	r.LockPayouts("x", 1000)
-// 	v := r.client.Get("test:payments:lock").Val()
-// 	if v != "x:1000" {
-// 		t.Errorf("Invalid lock amount: %v", v)
-// 	}
-
-// 	err := r.LockPayouts("x", 100)
-// 	if err == nil {
-// 		t.Errorf("Must not overwrite lock")
-// 	}
-// }
-
-// func TestUnlockPayouts(t *testing.T) {
-// 	reset()
-
-// 	r.client.Set(r.formatKey("payments:lock"), "x:1000", 0)
-
-// 	r.UnlockPayouts()
-// 	err := r.client.Get(r.formatKey("payments:lock")).Err()
-// 	if err != redis.Nil {
-// 		t.Errorf("Must release lock")
-// 	}
-// }
-
-// func TestIsPayoutsLocked(t *testing.T) {
-// 	reset()
-
-// 	r.LockPayouts("x", 1000)
-// 	if locked, _ := r.IsPayoutsLocked(); !locked {
-// 		t.Errorf("Payouts must be locked")
-// 	}
-// }
-
-// func TestUpdateBalance(t *testing.T) {
-// 	reset()
-
-// 	r.client.HMSetMap(
-// 		r.formatKey("miners:x"),
-// 		map[string]string{"paid": "50", "balance": "1000"},
-// 	)
-// 	r.client.HMSetMap(
-// 		r.formatKey("finances"),
-// 		map[string]string{"paid": "500", "balance": "10000"},
-// 	)
-
-// 	amount := int64(250)
-// 	r.UpdateBalance("x", amount)
-// 	result := r.client.HGetAllMap(r.formatKey("miners:x")).Val()
-// 	if result["pending"] != "250" {
-// 		t.Error("Must set pending amount")
-// 	}
-// 	if result["balance"] != "750" {
-// 		t.Error("Must deduct balance")
-// 	}
-// 	if result["paid"] != "50" {
-// 		t.Error("Must not touch paid")
-// 	}
-
-// 	result = r.client.HGetAllMap(r.formatKey("finances")).Val()
-// 	if result["pending"] != "250" {
-// 		t.Error("Must set pool pending amount")
-// 	}
-// 	if result["balance"] != "9750" {
-// 		t.Error("Must deduct pool balance")
-// 	}
-// 	if result["paid"] != "500" {
-// 		t.Error("Must not touch pool paid")
-// 	}
-
-// 	rank := r.client.ZRank(r.formatKey("payments:pending"), join("x", amount)).Val()
-// 	if rank != 0 {
-// 		t.Error("Must add pending payment")
-// 	}
-// }
-
-// func TestRollbackBalance(t *testing.T) {
-// 	reset()
-
-// 	r.client.HMSetMap(
-// 		r.formatKey("miners:x"),
-// 		map[string]string{"paid": "100", "balance": "750", "pending": "250"},
-// 	)
-// 	r.client.HMSetMap(
-// 		r.formatKey("finances"),
-// 		map[string]string{"paid": "500", "balance": "10000", "pending": "250"},
-// 	)
-// 	r.client.ZAdd(r.formatKey("payments:pending"), redis.Z{Score: 1, Member: "xx"})
-
-// 	amount := int64(250)
-// 	r.RollbackBalance("x", amount)
-// 	result := r.client.HGetAllMap(r.formatKey("miners:x")).Val()
-// 	if result["paid"] != "100" {
-// 		t.Error("Must not touch paid")
-// 	}
-// 	if result["balance"] != "1000" {
-// 		t.Error("Must increase balance")
-// 	}
-// 	if result["pending"] != "0" {
-// 		t.Error("Must deduct pending")
-// 	}
-
-// 	result = r.client.HGetAllMap(r.formatKey("finances")).Val()
-// 	if result["paid"] != "500" {
-// 		t.Error("Must not touch pool paid")
-// 	}
-// 	if result["balance"] != "10250" {
-// 		t.Error("Must increase pool balance")
-// 	}
-// 	if result["pending"] != "0" {
-// 		t.Error("Must deduct pool pending")
-// 	}
-
-// 	err := r.client.ZRank(r.formatKey("payments:pending"), join("x", amount)).Err()
-// 	if err != redis.Nil {
-// 		t.Errorf("Must remove pending payment")
-// 	}
-// }
-
-// func TestWritePayment(t *testing.T) {
-// 	reset()
-
-// 	r.client.HMSetMap(
-// 		r.formatKey("miners:x"),
-// 		map[string]string{"paid": "50", "balance": "1000", "pending": "250"},
-// 	)
-// 	r.client.HMSetMap(
-// 		r.formatKey("finances"),
-// 		map[string]string{"paid": "500", "balance": "10000", "pending": "250"},
-// 	)
-
-// 	amount := int64(250)
-// 	r.WritePayment("x", "0x0", amount)
-// 	result := r.client.HGetAllMap(r.formatKey("miners:x")).Val()
-// 	if result["pending"] != "0" {
-// 		t.Error("Must unset pending amount")
-// 	}
-// 	if result["balance"] != "1000" {
-// 		t.Error("Must not touch balance")
-// 	}
-// 	if result["paid"] != "300" {
-// 		t.Error("Must increase paid")
-// 	}
-
-// 	result = r.client.HGetAllMap(r.formatKey("finances")).Val()
-// 	if result["pending"] != "0" {
-// 		t.Error("Must deduct pool pending amount")
-// 	}
-// 	if result["balance"] != "10000" {
-// 		t.Error("Must not touch pool balance")
-// 	}
-// 	if result["paid"] != "750" {
-// 		t.Error("Must increase pool paid")
-// 	}
-
-// 	err := r.client.Get(r.formatKey("payments:lock")).Err()
-// 	if err != redis.Nil {
-// 		t.Errorf("Must release lock")
-// 	}
-
-// 	err = r.client.ZRank(r.formatKey("payments:pending"), join("x", amount)).Err()
-// 	if err != redis.Nil {
-// 		t.Error("Must remove pending payment")
-// 	}
-// 	err = r.client.ZRank(r.formatKey("payments:all"), join("0x0", "x", amount)).Err()
-// 	if err == redis.Nil {
-// 		t.Error("Must add payment to set")
-// 	}
-// 	err = r.client.ZRank(r.formatKey("payments:x"), join("0x0", amount)).Err()
-// 	if err == redis.Nil {
-// 		t.Error("Must add payment to set")
-// 	}
-// }
-
-// func TestGetPendingPayments(t *testing.T) {
-// 	reset()
-
-// 	r.client.HMSetMap(
-// 		r.formatKey("miners:x"),
-// 		map[string]string{"paid": "100", "balance": "750", "pending": "250"},
-// 	)
-
-// 	amount := int64(1000)
-// 	r.UpdateBalance("x", amount)
-// 	pending := r.GetPendingPayments()
-
-// 	if len(pending) != 1 {
-// 		t.Error("Must return pending payment")
-// 	}
-// 	if pending[0].Amount != amount {
-// 		t.Error("Must have corrent amount")
-// 	}
-// 	if pending[0].Address != "x" {
-// 		t.Error("Must have corrent account")
-// 	}
-// 	if pending[0].Timestamp <= 0 {
-// 		t.Error("Must have timestamp")
-// 	}
-// }
+func TestGetPayees(t *testing.T) {
+	reset()
+
+	n := 256
+	for i := 0; i < n; i++ {
+		r.client.HSet(r.formatKey("miners", strconv.Itoa(i)), "balance", strconv.Itoa(i))
+	}
+
+	var payees []string
+	payees, _ = r.GetPayees()
+	if len(payees) != n {
+		t.Error("Must return all payees")
+	}
+	m := make(map[string]struct{})
+	for _, v := range payees {
+		m[v] = struct{}{}
+	}
+	if len(m) != n {
+		t.Error("Must be unique list")
+	}
+}
+
+func TestGetBalance(t *testing.T) {
+	reset()
+
+	r.client.HSet(r.formatKey("miners:x"), "balance", "750")
+
+	v, _ := r.GetBalance("x")
+	if v != 750 {
+		t.Error("Must return balance")
+	}
+
+	v, err := r.GetBalance("z")
+	if v != 0 {
+		t.Error("Must return 0 if account does not exist")
+	}
+	if err != nil {
+		t.Error("Must not return error if account does not exist")
+	}
+}
+
+func TestMinPayout(t *testing.T) {
+	reset()
+
+	v, err := r.GetMinPayout("x")
+	if v != 0 || err != nil {
+		t.Error("Must return 0 if threshold is not set")
+	}
+	r.SetMinPayout("x", 50000000)
+	v, _ = r.GetMinPayout("x")
+	if v != 50000000 {
+		t.Error("Must return threshold set by miner")
+	}
+}
+
+func TestBans(t *testing.T) {
+	reset()
+
+	if banned, err := r.IsBanned("1.1.1.1"); banned || err != nil {
+		t.Error("Must not be banned without ban")
+	}
+	r.WriteBan("1.1.1.1", time.Minute)
+	if banned, _ := r.IsBanned("1.1.1.1"); !banned {
+		t.Error("Must be banned after ban is written")
+	}
+	if ttl := r.client.TTL(r.formatKey("bans", "1.1.1.1")).Val(); ttl <= 0 || ttl > time.Minute {
+		t.Errorf("Ban must expire after timeout, got TTL %v", ttl)
+	}
+}
+
+func TestLockPayouts(t *testing.T) {
+	reset()
+
+	r.LockPayouts("x", 1000)
+	v := r.client.Get("test:payments:lock").Val()
+	if v != "x:1000" {
+		t.Errorf("Invalid lock amount: %v", v)
+	}
+
+	err := r.LockPayouts("x", 100)
+	if err == nil {
+		t.Errorf("Must not overwrite lock")
+	}
+}
+
+func TestUnlockPayouts(t *testing.T) {
+	reset()
+
+	r.client.Set(r.formatKey("payments:lock"), "x:1000", 0)
+
+	r.UnlockPayouts()
+	err := r.client.Get(r.formatKey("payments:lock")).Err()
+	if err != redis.Nil {
+		t.Errorf("Must release lock")
+	}
+}
+
+func TestIsPayoutsLocked(t *testing.T) {
+	reset()
+
+	r.LockPayouts("x", 1000)
+	if locked, _ := r.IsPayoutsLocked(); !locked {
+		t.Errorf("Payouts must be locked")
+	}
+}
+
+func TestUpdateBalance(t *testing.T) {
+	reset()
+
+	r.client.HMSetMap(
+		r.formatKey("miners:x"),
+		map[string]string{"paid": "50", "balance": "1000"},
+	)
+	r.client.HMSetMap(
+		r.formatKey("finances"),
+		map[string]string{"paid": "500", "balance": "10000"},
+	)
+
+	amount := int64(250)
+	r.UpdateBalance("x", amount)
+	result := r.client.HGetAllMap(r.formatKey("miners:x")).Val()
+	if result["pending"] != "250" {
+		t.Error("Must set pending amount")
+	}
+	if result["balance"] != "750" {
+		t.Error("Must deduct balance")
+	}
+	if result["paid"] != "50" {
+		t.Error("Must not touch paid")
+	}
+
+	result = r.client.HGetAllMap(r.formatKey("finances")).Val()
+	if result["pending"] != "250" {
+		t.Error("Must set pool pending amount")
+	}
+	if result["balance"] != "9750" {
+		t.Error("Must deduct pool balance")
+	}
+	if result["paid"] != "500" {
+		t.Error("Must not touch pool paid")
+	}
+
+	rank := r.client.ZRank(r.formatKey("payments:pending"), join("x", amount)).Val()
+	if rank != 0 {
+		t.Error("Must add pending payment")
+	}
+}
+
+func TestRollbackBalance(t *testing.T) {
+	reset()
+
+	r.client.HMSetMap(
+		r.formatKey("miners:x"),
+		map[string]string{"paid": "100", "balance": "750", "pending": "250"},
+	)
+	r.client.HMSetMap(
+		r.formatKey("finances"),
+		map[string]string{"paid": "500", "balance": "10000", "pending": "250"},
+	)
+	r.client.ZAdd(r.formatKey("payments:pending"), redis.Z{Score: 1, Member: "xx"})
+
+	amount := int64(250)
+	r.RollbackBalance("x", amount)
+	result := r.client.HGetAllMap(r.formatKey("miners:x")).Val()
+	if result["paid"] != "100" {
+		t.Error("Must not touch paid")
+	}
+	if result["balance"] != "1000" {
+		t.Error("Must increase balance")
+	}
+	if result["pending"] != "0" {
+		t.Error("Must deduct pending")
+	}
+
+	result = r.client.HGetAllMap(r.formatKey("finances")).Val()
+	if result["paid"] != "500" {
+		t.Error("Must not touch pool paid")
+	}
+	if result["balance"] != "10250" {
+		t.Error("Must increase pool balance")
+	}
+	if result["pending"] != "0" {
+		t.Error("Must deduct pool pending")
+	}
+
+	err := r.client.ZRank(r.formatKey("payments:pending"), join("x", amount)).Err()
+	if err != redis.Nil {
+		t.Errorf("Must remove pending payment")
+	}
+}
+
+func TestWritePayment(t *testing.T) {
+	reset()
+
+	r.client.HMSetMap(
+		r.formatKey("miners:x"),
+		map[string]string{"paid": "50", "balance": "1000", "pending": "250"},
+	)
+	r.client.HMSetMap(
+		r.formatKey("finances"),
+		map[string]string{"paid": "500", "balance": "10000", "pending": "250"},
+	)
+
+	amount := int64(250)
+	r.WritePayment("x", "0x0", amount)
+	result := r.client.HGetAllMap(r.formatKey("miners:x")).Val()
+	if result["pending"] != "0" {
+		t.Error("Must unset pending amount")
+	}
+	if result["balance"] != "1000" {
+		t.Error("Must not touch balance")
+	}
+	if result["paid"] != "300" {
+		t.Error("Must increase paid")
+	}
+
+	result = r.client.HGetAllMap(r.formatKey("finances")).Val()
+	if result["pending"] != "0" {
+		t.Error("Must deduct pool pending amount")
+	}
+	if result["balance"] != "10000" {
+		t.Error("Must not touch pool balance")
+	}
+	if result["paid"] != "750" {
+		t.Error("Must increase pool paid")
+	}
+
+	err := r.client.Get(r.formatKey("payments:lock")).Err()
+	if err != redis.Nil {
+		t.Errorf("Must release lock")
+	}
+
+	err = r.client.ZRank(r.formatKey("payments:pending"), join("x", amount)).Err()
+	if err != redis.Nil {
+		t.Error("Must remove pending payment")
+	}
+	err = r.client.ZRank(r.formatKey("payments:all"), join("0x0", "x", amount)).Err()
+	if err == redis.Nil {
+		t.Error("Must add payment to set")
+	}
+	err = r.client.ZRank(r.formatKey("payments:x"), join("0x0", amount)).Err()
+	if err == redis.Nil {
+		t.Error("Must add payment to set")
+	}
+}
+
+func TestGetPendingPayments(t *testing.T) {
+	reset()
+
+	r.client.HMSetMap(
+		r.formatKey("miners:x"),
+		map[string]string{"paid": "100", "balance": "750", "pending": "250"},
+	)
+
+	amount := int64(1000)
+	r.UpdateBalance("x", amount)
+	pending := r.GetPendingPayments()
+
+	if len(pending) != 1 {
+		t.Error("Must return pending payment")
+	}
+	if pending[0].Amount != amount {
+		t.Error("Must have corrent amount")
+	}
+	if pending[0].Address != "x" {
+		t.Error("Must have corrent account")
+	}
+	if pending[0].Timestamp <= 0 {
+		t.Error("Must have timestamp")
+	}
+}
+
+func TestWritePayoutRun(t *testing.T) {
+	reset()
+
+	r.client.HMSetMap(r.formatKey("miners:x"), map[string]string{"balance": "1000"})
+	r.client.HMSetMap(r.formatKey("miners:z"), map[string]string{"balance": "500"})
+
+	r.WritePayoutRun("1", map[string]int64{"x": 1000, "z": 500})
+
+	run, _ := r.GetPayoutRun()
+	if run == nil {
+		t.Fatal("Must journal payout run")
+	}
+	if run.Id != "1" || run.Amount != 1500 {
+		t.Errorf("Invalid payout run: %v", run)
+	}
+	if len(run.Payments) != 2 {
+		t.Error("Must journal pending payment for each miner")
+	}
+	if r.client.HGet(r.formatKey("miners:x"), "balance").Val() != "0" {
+		t.Error("Must deduct balance")
+	}
+}
+
+func TestFinalizePayoutRun(t *testing.T) {
+	reset()
+
+	r.LockPayouts("1", 1500)
+	r.WritePayoutRun("1", map[string]int64{"x": 1000, "z": 500})
+	r.SetPayoutRunTx("0x0")
+
+	run, _ := r.GetPayoutRun()
+	if run.TxHash != "0x0" {
+		t.Error("Must journal tx hash")
+	}
+	r.FinalizePayoutRun(run, run.TxHash)
+
+	if run, _ = r.GetPayoutRun(); run != nil {
+		t.Error("Must remove payout run")
+	}
+	if locked, _ := r.IsPayoutsLocked(); locked {
+		t.Error("Must release lock")
+	}
+	if len(r.GetPendingPayments()) != 0 {
+		t.Error("Must remove pending payments")
+	}
+	if r.client.HGet(r.formatKey("miners:z"), "paid").Val() != "500" {
+		t.Error("Must increase paid")
+	}
+	err := r.client.ZRank(r.formatKey("payments:all"), join("0x0", "x", 1000)).Err()
+	if err == redis.Nil {
+		t.Error("Must add payment to set")
+	}
+}
+
+func TestRollbackPayoutRun(t *testing.T) {
+	reset()
+
+	r.client.HMSetMap(r.formatKey("miners:x"), map[string]string{"balance": "1000"})
+	r.LockPayouts("1", 1000)
+	r.WritePayoutRun("1", map[string]int64{"x": 1000})
+
+	run, _ := r.GetPayoutRun()
+	r.RollbackPayoutRun(run)
+
+	if run, _ = r.GetPayoutRun(); run != nil {
+		t.Error("Must remove payout run")
+	}
+	if locked, _ := r.IsPayoutsLocked(); locked {
+		t.Error("Must release lock")
+	}
+	result := r.client.HGetAllMap(r.formatKey("miners:x")).Val()
+	if result["balance"] != "1000" {
+		t.Error("Must restore balance")
+	}
+	if result["pending"] != "0" {
+		t.Error("Must deduct pending")
+	}
+}
 
 // func TestCollectLuckStats(t *testing.T) {
 // 	reset()
@@ -321,9 +439,103 @@ package storage
 // 	}
 // }
 
-// func reset() {
-// 	keys := r.client.Keys(r.prefix + ":*").Val()
-// 	for _, k := range keys {
-// 		r.client.Del(k)
-// 	}
-// }
+func TestCountPPLNSShares(t *testing.T) {
+	entries := []string{"a:100", "b:50", "a:100", "c:200"}
+
+	shares, used := countPPLNSShares(entries, 300)
+	if used != 4 || shares["a"] != 200 || shares["b"] != 50 || shares["c"] != 50 {
+		t.Errorf("Invalid window split %v, %v entries", shares, used)
+	}
+
+	shares, used = countPPLNSShares(entries, 1000)
+	if used != 4 || shares["c"] != 200 {
+		t.Errorf("Short window must take all shares %v, %v entries", shares, used)
+	}
+}
+
+func TestWriteBlockPPLNS(t *testing.T) {
+	reset()
+	r.EnablePPLNS(2)
+	defer r.EnablePPLNS(0)
+
+	for i := 0; i < 30; i++ {
+		r.WriteShare("x", "0", []string{"x", "1", "0", strconv.Itoa(i), "0"}, 10, 1000, time.Hour)
+	}
+	r.WriteShare("y", "0", []string{"y", "1", "0", "100", "0"}, 10, 1000, time.Hour)
+	r.WriteBlock("z", "0", []string{"z", "1", "0", "101", "0"}, 10, 20, 1000, time.Hour, 0, "00ab")
+
+	shares, _ := r.GetRoundShares(1000, "z")
+	if shares["z"] != 10 || shares["y"] != 10 || shares["x"] != 20 {
+		t.Errorf("Round must hold last 40 of shares: %v", shares)
+	}
+	if n := r.client.LLen(r.formatKey("shares", "pplns")).Val(); n != 8 {
+		t.Errorf("Window must be trimmed to 8 entries, got %v", n)
+	}
+}
+
+func TestPPLNSLimit(t *testing.T) {
+	reset()
+	r.EnablePPLNS(2)
+	r.SetPPLNSLimit(8)
+	defer r.EnablePPLNS(0)
+	defer r.SetPPLNSLimit(0)
+
+	for i := 0; i < 30; i++ {
+		r.WriteShare("x", "0", []string{"x", "1", "0", strconv.Itoa(i), "0"}, 10, 1000, time.Hour)
+	}
+	if n := r.client.LLen(r.formatKey("shares", "pplns")).Val(); n != 8 {
+		t.Errorf("Share list must be capped to 8 entries, got %v", n)
+	}
+	shares, _ := r.GetPPLNSShares(1000)
+	if shares["x"] != 80 {
+		t.Errorf("Window must be read from capped list only: %v", shares)
+	}
+}
+
+func reset() {
+	keys := r.client.Keys(r.prefix + ":*").Val()
+	for _, k := range keys {
+		r.client.Del(k)
+	}
+}
+
+func TestWalletFunds(t *testing.T) {
+	reset()
+
+	r.WriteShieldingOperation("opid-1", 2000)
+	op, _ := r.GetShieldingOperation()
+	if op == nil || op.Opid != "opid-1" || op.Amount != 2000 {
+		t.Fatalf("Invalid shielding operation: %v", op)
+	}
+	r.WriteShieldingResult(op, "0x1", "")
+	if op, _ = r.GetShieldingOperation(); op != nil {
+		t.Error("Must clear finished shielding operation")
+	}
+
+	pending, _ := r.GetPendingShieldedTxs()
+	if len(pending) != 1 || pending[0].TxHash != "0x1" || pending[0].Amount != 2000 {
+		t.Fatalf("Invalid pending shielding txs: %v", pending)
+	}
+	r.ReleaseShieldedTx(pending[0], 10)
+	if available, _ := r.GetWalletAvailable(); available != 1990 {
+		t.Errorf("Must release shielded funds less fee, got %v", available)
+	}
+
+	r.client.HMSetMap(r.formatKey("miners:x"), map[string]string{"balance": "1000"})
+	r.LockPayouts("1", 1000)
+	r.WriteShieldedPayoutRun("1", map[string]int64{"x": 1000}, true)
+	if available, _ := r.GetWalletAvailable(); available != 990 {
+		t.Errorf("Must spend shielded funds, got %v", available)
+	}
+	run, _ := r.GetPayoutRun()
+	if run == nil || !run.Shielded || !run.FromWallet {
+		t.Fatalf("Invalid payout run: %v", run)
+	}
+	r.WriteFailedPayoutRun(run, "Insufficient funds")
+	if available, _ := r.GetWalletAvailable(); available != 1990 {
+		t.Errorf("Must refund shielded funds of failed run, got %v", available)
+	}
+	if r.client.HGet(r.formatKey("miners:x"), "balance").Val() != "1000" {
+		t.Error("Must credit back balance")
+	}
+}
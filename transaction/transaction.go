@@ -1,43 +1,89 @@
 package transaction
 
 import (
-	"math"
-	"strconv"
-
-	zaddr "github.com/OpenBazaar/multiwallet/zcash/address"
-	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcd/wire"
 	"github.com/jkkgbe/open-zcash-pool/util"
 	zecl "github.com/jkkgbe/zcash-light"
 )
 
-func BuildCoinbaseTxn(blockHeight int64, poolAddress string, foundersRewardZatoshi int64, feeReward int64) ([]byte, chainhash.Hash) {
-	blockHeightAsHex := strconv.FormatInt(blockHeight, 16)
+const (
+	opReserved    = 0x50
+	opDup         = 0x76
+	opHash160     = 0xa9
+	opEqual       = 0x87
+	opEqualVerify = 0x88
+	opCheckSig    = 0xac
+)
 
-	var blockHeightSerial string
+type Output struct {
+	Address string
+	Value   int64
+}
+
+// Builds P2PKH or P2SH script paying to a transparent address of the active network
+func PayToAddrScript(address string) ([]byte, error) {
+	hash, isScriptHash, err := util.ActiveNetwork().DecodeTAddress(address)
+	if err != nil {
+		return nil, err
+	}
 
-	if len(blockHeightAsHex)%2 != 0 {
-		blockHeightSerial = "0" + blockHeightAsHex
-	} else {
-		blockHeightSerial = blockHeightAsHex
+	if isScriptHash {
+		script := append([]byte{opHash160, 0x14}, hash...)
+		return append(script, opEqual), nil
 	}
+	script := append([]byte{opDup, opHash160, 0x14}, hash...)
+	return append(script, opEqualVerify, opCheckSig), nil
+}
 
-	height := math.Ceil(float64(len(strconv.FormatInt(blockHeight<<1, 2))) / 8)
-	lengthDiff := len(blockHeightSerial)/2 - int(height)
+type Coinbase struct {
+	Data       []byte
+	Hash       chainhash.Hash
+	AuthDigest chainhash.Hash
+}
 
-	for i := 0; i < lengthDiff; i++ {
-		blockHeightSerial += "00"
+// Auth digest of pre-v5 transactions in the block auth data tree (ZIP 244)
+var legacyAuthDigest = chainhash.Hash{
+	0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+	0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+}
+
+// Height push required by BIP 34 followed by an empty push, the script
+// must be at least two bytes long
+func coinbaseScript(blockHeight int64) []byte {
+	if blockHeight >= 1 && blockHeight <= 16 {
+		return []byte{opReserved + byte(blockHeight), 0}
 	}
 
-	length := "0" + strconv.FormatFloat(height, 'f', 0, 64)
+	var serial []byte
+	for n := blockHeight; n > 0; n >>= 8 {
+		serial = append(serial, byte(n))
+	}
+	if len(serial) > 0 && serial[len(serial)-1]&0x80 != 0 {
+		serial = append(serial, 0)
+	}
 
-	var blockHeightScript []byte
+	script := append([]byte{byte(len(serial))}, serial...)
+	return append(script, 0)
+}
 
-	blockHeightScript = append(blockHeightScript, util.HexToBytes(length)...)
-	blockHeightScript = append(blockHeightScript, util.ReverseBuffer(util.HexToBytes(blockHeightSerial))...)
-	blockHeightScript = append(blockHeightScript, []byte{0}...)
+func coinbaseOutputs(outputs []Output) ([]zecl.Output, error) {
+	var txOutputs []zecl.Output
+	for _, output := range outputs {
+		script, err := PayToAddrScript(output.Address)
+		if err != nil {
+			return nil, err
+		}
+		txOutputs = append(txOutputs, zecl.Output{
+			Value:        output.Value,
+			ScriptPubKey: script,
+		})
+	}
+	return txOutputs, nil
+}
 
+// Builds v4 Sapling coinbase used before NU5
+func BuildCoinbaseTxn(blockHeight int64, outputs []Output) (*Coinbase, error) {
 	var hash32 [32]byte
 	copy(hash32[:], make([]byte, 32))
 
@@ -48,18 +94,13 @@ func BuildCoinbaseTxn(blockHeight int64, poolAddress string, foundersRewardZatos
 
 	input := zecl.Input{
 		PreviousOutPoint: coinbasePrevOutpoint,
-		SignatureScript:  blockHeightScript,
+		SignatureScript:  coinbaseScript(blockHeight),
 		Sequence:         4294967295,
 	}
 
-	// Calculate which founder
-	index := int(math.Floor(float64(blockHeight) / util.FoundersRewardAddressChangeInterval))
-
-	poolAddressScriptFormat, _ := zaddr.DecodeAddress(poolAddress, &chaincfg.TestNet3Params)
-	poolScript, _ := zaddr.PayToAddrScript(poolAddressScriptFormat)
-	outputPool := zecl.Output{
-		Value:        util.GetConstReward(blockHeight).Int64() + feeReward,
-		ScriptPubKey: poolScript,
+	txOutputs, err := coinbaseOutputs(outputs)
+	if err != nil {
+		return nil, err
 	}
 
 	transaction := zecl.Transaction{
@@ -71,21 +112,13 @@ func BuildCoinbaseTxn(blockHeight int64, poolAddress string, foundersRewardZatos
 		ValueBalance:          0,
 		TemporaryUnknownValue: 0,
 		Inputs:                []zecl.Input{input},
-		Outputs:               []zecl.Output{outputPool},
+		Outputs:               txOutputs,
 	}
 
-	if blockHeight < util.FirstRewardHalvingBlock {
-		foundersAddressScriptFormat, _ := zaddr.DecodeAddress(util.TestFoundersRewardAddresses[index], &chaincfg.TestNet3Params)
-		founderScript, _ := zaddr.PayToAddrScript(foundersAddressScriptFormat)
-		outputFounders := zecl.Output{
-			Value:        foundersRewardZatoshi,
-			ScriptPubKey: founderScript,
-		}
-
-		transaction.Outputs = append(transaction.Outputs, outputFounders)
+	transactionBytes, err := transaction.MarshalBinary()
+	if err != nil {
+		return nil, err
 	}
 
-	transactionBytes, _ := transaction.MarshalBinary()
-
-	return transactionBytes, transaction.TxHash()
+	return &Coinbase{Data: transactionBytes, Hash: transaction.TxHash(), AuthDigest: legacyAuthDigest}, nil
 }
@@ -0,0 +1,125 @@
+package transaction
+
+import (
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/dchest/blake2b"
+	"github.com/jkkgbe/open-zcash-pool/util"
+)
+
+const (
+	txVersion5         uint32 = 5 | 1<<31
+	txVersionGroupIdV5 uint32 = 0x26A7270A
+)
+
+// Builds v5 coinbase (ZIP 225) required since NU5, its txid and auth digest
+// follow ZIP 244 and commit to the consensus branch id of the block
+func BuildCoinbaseTxnV5(blockHeight int64, branchId uint32, outputs []Output) (*Coinbase, error) {
+	txOutputs, err := coinbaseOutputs(outputs)
+	if err != nil {
+		return nil, err
+	}
+
+	header := util.PackUInt32LE(txVersion5)
+	header = append(header, util.PackUInt32LE(txVersionGroupIdV5)...)
+	header = append(header, util.PackUInt32LE(branchId)...)
+	// Lock time
+	header = append(header, util.PackUInt32LE(0)...)
+	// Coinbase expires at its own height (ZIP 203)
+	header = append(header, util.PackUInt32LE(uint32(blockHeight))...)
+
+	var prevout []byte
+	prevout = append(prevout, make([]byte, 32)...)
+	prevout = append(prevout, util.PackUInt32LE(0xffffffff)...)
+	sequence := util.PackUInt32LE(0xffffffff)
+
+	script := coinbaseScript(blockHeight)
+	scriptSig := append(util.PackVarInt(uint64(len(script))), script...)
+
+	var serializedOutputs []byte
+	for _, output := range txOutputs {
+		serializedOutputs = append(serializedOutputs, util.PackUInt64LE(uint64(output.Value))...)
+		serializedOutputs = append(serializedOutputs, util.PackVarInt(uint64(len(output.ScriptPubKey)))...)
+		serializedOutputs = append(serializedOutputs, output.ScriptPubKey...)
+	}
+
+	data := append([]byte{}, header...)
+	data = append(data, util.PackVarInt(1)...)
+	data = append(data, prevout...)
+	data = append(data, scriptSig...)
+	data = append(data, sequence...)
+	data = append(data, util.PackVarInt(uint64(len(txOutputs)))...)
+	data = append(data, serializedOutputs...)
+	// No Sapling spends and outputs, no Orchard actions
+	data = append(data, 0, 0, 0)
+
+	transparentDigest := blake2b256("ZTxIdTranspaHash",
+		blake2b256("ZTxIdPrevoutHash", prevout),
+		blake2b256("ZTxIdSequencHash", sequence),
+		blake2b256("ZTxIdOutputsHash", serializedOutputs),
+	)
+	txid := blake2b256(branchPersonalization("ZcashTxHash_", branchId),
+		blake2b256("ZTxIdHeadersHash", header),
+		transparentDigest,
+		blake2b256("ZTxIdSaplingHash"),
+		blake2b256("ZTxIdOrchardHash"),
+	)
+	authDigest := blake2b256(branchPersonalization("ZTxAuthHash_", branchId),
+		blake2b256("ZTxAuthTransHash", scriptSig),
+		blake2b256("ZTxAuthSapliHash"),
+		blake2b256("ZTxAuthOrchaHash"),
+	)
+
+	coinbase := &Coinbase{Data: data}
+	copy(coinbase.Hash[:], txid)
+	copy(coinbase.AuthDigest[:], authDigest)
+	return coinbase, nil
+}
+
+// Root of the auth data tree of a block, digests of its transactions in block order
+func AuthDataRoot(authDigests []chainhash.Hash) chainhash.Hash {
+	size := 1
+	for size < len(authDigests) {
+		size <<= 1
+	}
+
+	layer := make([][]byte, size)
+	for i := range layer {
+		layer[i] = make([]byte, 32)
+		if i < len(authDigests) {
+			copy(layer[i], authDigests[i][:])
+		}
+	}
+
+	for len(layer) > 1 {
+		for i := 0; i < len(layer)/2; i++ {
+			layer[i] = blake2b256("ZcashAuthDatHash", layer[2*i], layer[2*i+1])
+		}
+		layer = layer[:len(layer)/2]
+	}
+
+	var root chainhash.Hash
+	copy(root[:], layer[0])
+	return root
+}
+
+// Header commitment replacing the final Sapling root since NU5 (ZIP 244)
+func BlockCommitmentsHash(chainHistoryRoot, authDataRoot chainhash.Hash) chainhash.Hash {
+	var hash chainhash.Hash
+	copy(hash[:], blake2b256("ZcashBlockCommit", chainHistoryRoot[:], authDataRoot[:], make([]byte, 32)))
+	return hash
+}
+
+func branchPersonalization(prefix string, branchId uint32) string {
+	return prefix + string(util.PackUInt32LE(branchId))
+}
+
+func blake2b256(personalization string, data ...[]byte) []byte {
+	hash, err := blake2b.New(&blake2b.Config{Size: 32, Person: []byte(personalization)})
+	if err != nil {
+		panic(err)
+	}
+	for _, d := range data {
+		hash.Write(d)
+	}
+	return hash.Sum(nil)
+}
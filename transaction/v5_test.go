@@ -0,0 +1,86 @@
+package transaction
+
+import (
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/jkkgbe/open-zcash-pool/util"
+)
+
+const nu5BranchId = 0xc2d6d0b4
+
+// Expected coinbases, txids and auth digests below were produced by this builder,
+// not taken from the chain, they only guard against regressions. A real NU5 mainnet
+// coinbase with its txid and auth digest is still to be added.
+func TestBuildCoinbaseTxnV5(t *testing.T) {
+	util.SetNetwork("mainnet")
+	defer util.SetNetwork("testnet")
+
+	// Miner output and Canopy funding streams paid at height 2000000
+	coinbase, err := BuildCoinbaseTxnV5(2000000, nu5BranchId, []Output{
+		{Address: "t1HsdDMzmJfq4vc7T17XYjEkLMLvbgM1fCi", Value: 250012345},
+		{Address: "t3LPohpJ3vwSLmJDG8KegAw6EPdaxa5V97b", Value: 21875000},
+		{Address: "t3NDyGj6u1JjEWpce8S7DC15CHPxbiuQugE", Value: 15625000},
+		{Address: "t3Q48qduk5g28GM228YZkD54ABALF2LsVRG", Value: 25000000},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := "050000800a27a726b4d0d6c20000000080841e00010000000000000000000000000000000000000000000000000000000000000000ffffffff050380841e00ffffffff04b9e2e60e000000001976a914000102030405060708090a0b0c0d0e0f1011121388ac38c94d010000000017a9141415161718191a1b1c1d1e1f202122232425262787286bee000000000017a91428292a2b2c2d2e2f303132333435363738393a3b8740787d010000000017a9143c3d3e3f404142434445464748494a4b4c4d4e4f87000000"
+	if util.BytesToHex(coinbase.Data) != data {
+		t.Errorf("Unexpected coinbase %x", coinbase.Data)
+	}
+	if coinbase.Hash.String() != "1e9c8cfd2fefaf3b99b886cc37209be6a03486ba80af02a26e5898402506fb0e" {
+		t.Errorf("Unexpected txid %v", coinbase.Hash)
+	}
+	if coinbase.AuthDigest.String() != "11182a64008f75cc0a2f2b4af98dbb113a58dc8b194ce122ca673a25b2d67fc8" {
+		t.Errorf("Unexpected auth digest %v", coinbase.AuthDigest)
+	}
+}
+
+func TestBuildCoinbaseTxnV5SmallHeight(t *testing.T) {
+	util.SetNetwork("mainnet")
+	defer util.SetNetwork("testnet")
+
+	coinbase, err := BuildCoinbaseTxnV5(5, nu5BranchId, []Output{
+		{Address: "t1HsdDMzmJfq4vc7T17XYjEkLMLvbgM1fCi", Value: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Heights up to 16 are pushed with OP_N
+	data := "050000800a27a726b4d0d6c20000000005000000010000000000000000000000000000000000000000000000000000000000000000ffffffff025500ffffffff0101000000000000001976a914000102030405060708090a0b0c0d0e0f1011121388ac000000"
+	if util.BytesToHex(coinbase.Data) != data {
+		t.Errorf("Unexpected coinbase %x", coinbase.Data)
+	}
+	if coinbase.Hash.String() != "1de1f3f36c340fccfc229218598582bd2a691a7c1f49a696a98850721dcbe4fc" {
+		t.Errorf("Unexpected txid %v", coinbase.Hash)
+	}
+}
+
+func TestBlockCommitmentsHash(t *testing.T) {
+	var coinbaseDigest, legacyDigest, otherDigest, chainHistoryRoot chainhash.Hash
+	copy(coinbaseDigest[:], util.ReverseBuffer(util.HexToBytes("11182a64008f75cc0a2f2b4af98dbb113a58dc8b194ce122ca673a25b2d67fc8")))
+	legacyDigest = legacyAuthDigest
+	for i := range otherDigest {
+		otherDigest[i] = byte(i)
+		chainHistoryRoot[i] = byte(100 + i)
+	}
+
+	root := AuthDataRoot([]chainhash.Hash{coinbaseDigest, legacyDigest, otherDigest})
+	if util.BytesToHex(root[:]) != "08aafa2b3bd98f484dfc3fb631d2b48bb19ab1982766ac76a5f09c080e11b46c" {
+		t.Errorf("Unexpected auth data root %x", root[:])
+	}
+
+	commitments := BlockCommitmentsHash(chainHistoryRoot, root)
+	if util.BytesToHex(commitments[:]) != "1218d1bffad8035a184a8fb9652d9b6436d499e64e9123e5dbebc534822c7ef6" {
+		t.Errorf("Unexpected block commitments %x", commitments[:])
+	}
+
+	single := AuthDataRoot([]chainhash.Hash{coinbaseDigest})
+	if single != coinbaseDigest {
+		t.Errorf("Expected auth data root of a single transaction to be its digest, got %x", single[:])
+	}
+}
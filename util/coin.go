@@ -0,0 +1,155 @@
+package util
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+)
+
+// Coin definition file, fields left out are inherited from the selected network
+type coinFile struct {
+	Name      string `json:"name"`
+	Symbol    string `json:"symbol"`
+	Algorithm string `json:"algorithm"`
+
+	PubKeyHashAddrID string `json:"pubKeyHashAddrID"`
+	ScriptHashAddrID string `json:"scriptHashAddrID"`
+	SaplingHRP       string `json:"saplingHrp"`
+	UnifiedHRP       string `json:"unifiedHrp"`
+	PowLimit         string `json:"powLimit"`
+
+	BlockSubsidy            int64      `json:"blockSubsidy"`
+	SlowStartInterval       int64      `json:"slowStartInterval"`
+	HalvingInterval         int64      `json:"halvingInterval"`
+	BlossomActivationHeight int64      `json:"blossomActivationHeight"`
+	CanopyActivationHeight  int64      `json:"canopyActivationHeight"`
+	NU5ActivationHeight     int64      `json:"nu5ActivationHeight"`
+	FundingStreamPeriods    [][2]int64 `json:"fundingStreamPeriods"`
+
+	Equihash struct {
+		N               int    `json:"n"`
+		K               int    `json:"k"`
+		Personalization string `json:"personalization"`
+	} `json:"equihash"`
+
+	PayFoundersReward            bool     `json:"payFoundersReward"`
+	PercentFoundersReward        int64    `json:"percentFoundersReward"`
+	MaxFoundersRewardBlockHeight int64    `json:"maxFoundersRewardBlockHeight"`
+	FoundersRewardAddresses      []string `json:"vFoundersRewardAddress"`
+}
+
+// Activates chain parameters read from a coin file on top of the active network
+func LoadCoin(fileName string) error {
+	base := activeNetwork
+
+	// Slices are copied, decoding reuses their backing arrays
+	coin := coinFile{
+		Name:                         base.Name,
+		Symbol:                       base.Symbol,
+		Algorithm:                    "equihash",
+		PubKeyHashAddrID:             hex.EncodeToString(base.PubKeyHashAddrID),
+		ScriptHashAddrID:             hex.EncodeToString(base.ScriptHashAddrID),
+		SaplingHRP:                   base.SaplingHRP,
+		UnifiedHRP:                   base.UnifiedHRP,
+		PowLimit:                     base.PowLimit.Text(16),
+		BlockSubsidy:                 base.MaxBlockSubsidy,
+		SlowStartInterval:            base.SlowStartInterval,
+		HalvingInterval:              base.PreBlossomHalvingInterval,
+		BlossomActivationHeight:      base.BlossomActivationHeight,
+		CanopyActivationHeight:       base.CanopyActivationHeight,
+		NU5ActivationHeight:          base.NU5ActivationHeight,
+		FundingStreamPeriods:         append([][2]int64(nil), base.FundingStreamPeriods...),
+		PayFoundersReward:            base.FoundersRewardPercent > 0,
+		PercentFoundersReward:        base.FoundersRewardPercent,
+		MaxFoundersRewardBlockHeight: base.MaxFoundersRewardHeight,
+		FoundersRewardAddresses:      append([]string(nil), base.FoundersRewardAddresses...),
+	}
+	coin.Equihash.N = base.EquihashN
+	coin.Equihash.K = base.EquihashK
+	coin.Equihash.Personalization = base.EquihashPersonalization
+
+	file, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := json.NewDecoder(file).Decode(&coin); err != nil {
+		return err
+	}
+
+	network, err := coin.network()
+	if err != nil {
+		return fmt.Errorf("coin %s: %v", coin.Name, err)
+	}
+	activeNetwork = network
+	return nil
+}
+
+func (coin *coinFile) network() (*Network, error) {
+	if coin.Algorithm != "equihash" {
+		return nil, fmt.Errorf("unsupported algorithm %s", coin.Algorithm)
+	}
+	if len(coin.Equihash.Personalization) != 8 {
+		return nil, errors.New("equihash personalization must be 8 characters long")
+	}
+	if coin.BlockSubsidy <= 0 || coin.HalvingInterval <= 0 {
+		return nil, errors.New("block subsidy and halving interval must be positive")
+	}
+
+	network := &Network{
+		Name:                      coin.Name,
+		Symbol:                    coin.Symbol,
+		SaplingHRP:                coin.SaplingHRP,
+		UnifiedHRP:                coin.UnifiedHRP,
+		MaxBlockSubsidy:           coin.BlockSubsidy,
+		SlowStartInterval:         coin.SlowStartInterval,
+		PreBlossomHalvingInterval: coin.HalvingInterval,
+		BlossomActivationHeight:   coin.BlossomActivationHeight,
+		CanopyActivationHeight:    coin.CanopyActivationHeight,
+		NU5ActivationHeight:       coin.NU5ActivationHeight,
+		FundingStreamPeriods:      coin.FundingStreamPeriods,
+		EquihashN:                 coin.Equihash.N,
+		EquihashK:                 coin.Equihash.K,
+		EquihashPersonalization:   coin.Equihash.Personalization,
+		MaxFoundersRewardHeight:   coin.MaxFoundersRewardBlockHeight,
+		FoundersRewardAddresses:   coin.FoundersRewardAddresses,
+	}
+
+	var ok bool
+	if network.PowLimit, ok = new(big.Int).SetString(coin.PowLimit, 16); !ok {
+		return nil, fmt.Errorf("malformed powLimit %s", coin.PowLimit)
+	}
+	var err error
+	if network.PubKeyHashAddrID, err = readAddrID(coin.PubKeyHashAddrID); err != nil {
+		return nil, err
+	}
+	if network.ScriptHashAddrID, err = readAddrID(coin.ScriptHashAddrID); err != nil {
+		return nil, err
+	}
+
+	if coin.PayFoundersReward {
+		if coin.PercentFoundersReward <= 0 || len(coin.FoundersRewardAddresses) == 0 {
+			return nil, errors.New("founders reward requires percentFoundersReward and vFoundersRewardAddress")
+		}
+		network.FoundersRewardPercent = coin.PercentFoundersReward
+		for _, address := range coin.FoundersRewardAddresses {
+			if _, _, err := network.DecodeTAddress(address); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return network, nil
+}
+
+// Address version prefix is one byte on Bitcoin style forks and two bytes on Zcash
+func readAddrID(s string) ([]byte, error) {
+	id, err := hex.DecodeString(s)
+	if err != nil || len(id) < 1 || len(id) > 2 {
+		return nil, fmt.Errorf("malformed address prefix %s, 1 or 2 hex encoded bytes required", s)
+	}
+	return id, nil
+}
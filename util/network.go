@@ -0,0 +1,385 @@
+package util
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/jkkgbe/open-zcash-pool/address"
+)
+
+// Activation height of a network upgrade which never activates
+const NoActivationHeight int64 = -1
+
+const blossomPowTargetSpacingRatio = 2
+
+type Network struct {
+	Name   string
+	Symbol string
+
+	PowLimit         *big.Int
+	PubKeyHashAddrID []byte
+	ScriptHashAddrID []byte
+	SaplingHRP       string
+	UnifiedHRP       string
+
+	MaxBlockSubsidy           int64
+	SlowStartInterval         int64
+	PreBlossomHalvingInterval int64
+	BlossomActivationHeight   int64
+	CanopyActivationHeight    int64
+	NU5ActivationHeight       int64
+
+	// Height ranges in which the dev fund takes a fifth of the subsidy
+	FundingStreamPeriods [][2]int64
+
+	EquihashN               int
+	EquihashK               int
+	EquihashPersonalization string
+
+	FoundersRewardPercent int64
+	// Derived from the first halving when zero
+	MaxFoundersRewardHeight int64
+	FoundersRewardAddresses []string
+}
+
+var PowLimitMain = new(big.Int).Sub(math.BigPow(2, 243), big.NewInt(1))
+var PowLimitTest = new(big.Int).Sub(math.BigPow(2, 251), big.NewInt(1))
+var PowLimitRegtest = new(big.Int).SetBytes(common.FromHex("0f0f0f0f0f0f0f0f0f0f0f0f0f0f0f0f0f0f0f0f0f0f0f0f0f0f0f0f0f0f0f0f"))
+
+var MainNet = &Network{
+	Name:                      "mainnet",
+	Symbol:                    "zec",
+	PowLimit:                  PowLimitMain,
+	PubKeyHashAddrID:          []byte{0x1c, 0xb8},
+	ScriptHashAddrID:          []byte{0x1c, 0xbd},
+	SaplingHRP:                "zs",
+	UnifiedHRP:                "u",
+	MaxBlockSubsidy:           1250000000,
+	SlowStartInterval:         20000,
+	PreBlossomHalvingInterval: 840000,
+	BlossomActivationHeight:   653600,
+	CanopyActivationHeight:    1046400,
+	NU5ActivationHeight:       1687104,
+	FundingStreamPeriods:      [][2]int64{{1046400, 3146400}},
+	EquihashN:                 200,
+	EquihashK:                 9,
+	EquihashPersonalization:   "ZcashPoW",
+	FoundersRewardPercent:     20,
+	FoundersRewardAddresses:   mainFoundersRewardAddresses,
+}
+
+var TestNet = &Network{
+	Name:                      "testnet",
+	Symbol:                    "taz",
+	PowLimit:                  PowLimitTest,
+	PubKeyHashAddrID:          []byte{0x1d, 0x25},
+	ScriptHashAddrID:          []byte{0x1c, 0xba},
+	SaplingHRP:                "ztestsapling",
+	UnifiedHRP:                "utest",
+	MaxBlockSubsidy:           1250000000,
+	SlowStartInterval:         20000,
+	PreBlossomHalvingInterval: 840000,
+	BlossomActivationHeight:   584000,
+	CanopyActivationHeight:    1028500,
+	NU5ActivationHeight:       1842420,
+	FundingStreamPeriods:      [][2]int64{{1028500, 2796000}, {2976000, 3396000}},
+	EquihashN:                 200,
+	EquihashK:                 9,
+	EquihashPersonalization:   "ZcashPoW",
+	FoundersRewardPercent:     20,
+	FoundersRewardAddresses:   testFoundersRewardAddresses,
+}
+
+var RegTest = &Network{
+	Name:                      "regtest",
+	Symbol:                    "taz",
+	PowLimit:                  PowLimitRegtest,
+	PubKeyHashAddrID:          []byte{0x1d, 0x25},
+	ScriptHashAddrID:          []byte{0x1c, 0xba},
+	SaplingHRP:                "zregtestsapling",
+	UnifiedHRP:                "uregtest",
+	MaxBlockSubsidy:           1250000000,
+	SlowStartInterval:         0,
+	PreBlossomHalvingInterval: 144,
+	BlossomActivationHeight:   NoActivationHeight,
+	CanopyActivationHeight:    NoActivationHeight,
+	NU5ActivationHeight:       NoActivationHeight,
+	EquihashN:                 48,
+	EquihashK:                 5,
+	EquihashPersonalization:   "ZcashPoW",
+	FoundersRewardPercent:     20,
+	FoundersRewardAddresses:   []string{"t2FwcEhFdNXuFMv1tcYwaBJtYVtMj8b1uTg"},
+}
+
+var networks = map[string]*Network{
+	MainNet.Name: MainNet,
+	TestNet.Name: TestNet,
+	RegTest.Name: RegTest,
+}
+
+var activeNetwork = TestNet
+
+// Selects network parameters used across the pool, empty name keeps testnet
+func SetNetwork(name string) error {
+	if len(name) == 0 {
+		name = TestNet.Name
+	}
+	network, ok := networks[name]
+	if !ok {
+		return fmt.Errorf("unknown network %q, use mainnet, testnet or regtest", name)
+	}
+	activeNetwork = network
+	return nil
+}
+
+func ActiveNetwork() *Network {
+	return activeNetwork
+}
+
+func isActive(activationHeight, height int64) bool {
+	return activationHeight != NoActivationHeight && height >= activationHeight
+}
+
+func (network *Network) IsBlossomActive(height int64) bool {
+	return isActive(network.BlossomActivationHeight, height)
+}
+
+func (network *Network) IsCanopyActive(height int64) bool {
+	return isActive(network.CanopyActivationHeight, height)
+}
+
+func (network *Network) IsNU5Active(height int64) bool {
+	return isActive(network.NU5ActivationHeight, height)
+}
+
+func (network *Network) slowStartShift() int64 {
+	return network.SlowStartInterval / 2
+}
+
+func (network *Network) postBlossomHalvingInterval() int64 {
+	return network.PreBlossomHalvingInterval * blossomPowTargetSpacingRatio
+}
+
+// Number of halvings which occurred before the given height (ZIP 208)
+func (network *Network) Halving(height int64) int64 {
+	if network.IsBlossomActive(height) {
+		scaledHalvings := (network.BlossomActivationHeight-network.slowStartShift())*blossomPowTargetSpacingRatio +
+			(height - network.BlossomActivationHeight)
+		return scaledHalvings / network.postBlossomHalvingInterval()
+	}
+	return (height - network.slowStartShift()) / network.PreBlossomHalvingInterval
+}
+
+// Height of the halvingIndex-th halving as scheduled at the given height
+func (network *Network) HalvingHeight(height, halvingIndex int64) int64 {
+	if network.IsBlossomActive(height) {
+		return halvingIndex*network.postBlossomHalvingInterval() -
+			(network.BlossomActivationHeight-network.slowStartShift())*blossomPowTargetSpacingRatio +
+			network.BlossomActivationHeight
+	}
+	return halvingIndex*network.PreBlossomHalvingInterval + network.slowStartShift()
+}
+
+func (network *Network) lastFoundersRewardHeight(height int64) int64 {
+	if network.MaxFoundersRewardHeight > 0 {
+		return network.MaxFoundersRewardHeight
+	}
+	return network.HalvingHeight(height, 1) - 1
+}
+
+func (network *Network) BlockSubsidy(height int64) int64 {
+	subsidy := network.MaxBlockSubsidy
+
+	if height < network.slowStartShift() {
+		return subsidy / network.SlowStartInterval * height
+	} else if height < network.SlowStartInterval {
+		return subsidy / network.SlowStartInterval * (height + 1)
+	}
+
+	halvings := network.Halving(height)
+	if halvings >= 64 {
+		return 0
+	}
+	if network.IsBlossomActive(height) {
+		return (subsidy / blossomPowTargetSpacingRatio) >> uint(halvings)
+	}
+	return subsidy >> uint(halvings)
+}
+
+func (network *Network) IsFoundersRewardHeight(height int64) bool {
+	return network.FoundersRewardPercent > 0 && height > 0 &&
+		height <= network.lastFoundersRewardHeight(height) && !network.IsCanopyActive(height)
+}
+
+func (network *Network) FoundersReward(height int64) int64 {
+	if !network.IsFoundersRewardHeight(height) {
+		return 0
+	}
+	return network.BlockSubsidy(height) * network.FoundersRewardPercent / 100
+}
+
+func (network *Network) FundingStreamsReward(height int64) int64 {
+	for _, period := range network.FundingStreamPeriods {
+		if height >= period[0] && height < period[1] {
+			return network.BlockSubsidy(height) / 5
+		}
+	}
+	return 0
+}
+
+// Part of the subsidy left to the pool after founders and dev fund outputs
+func (network *Network) MinerReward(height int64) int64 {
+	return network.BlockSubsidy(height) - network.FoundersReward(height) - network.FundingStreamsReward(height)
+}
+
+func (network *Network) FoundersRewardAddress(height int64) string {
+	preBlossomMaxHeight := network.lastFoundersRewardHeight(0)
+	if network.IsBlossomActive(height) {
+		// Index stays the same as if it were pre-Blossom
+		height = network.BlossomActivationHeight + (height-network.BlossomActivationHeight)/blossomPowTargetSpacingRatio
+	}
+	count := int64(len(network.FoundersRewardAddresses))
+	addressChangeInterval := (preBlossomMaxHeight + count) / count
+	return network.FoundersRewardAddresses[height/addressChangeInterval]
+}
+
+// Decodes transparent address into its hash160, reporting whether it is P2SH
+func (network *Network) DecodeTAddress(s string) ([]byte, bool, error) {
+	decoded, err := address.DecodeBase58Check(s)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(decoded) < 21 {
+		return nil, false, errors.New("malformed address")
+	}
+
+	prefix, hash := decoded[:len(decoded)-20], decoded[len(decoded)-20:]
+	switch {
+	case bytes.Equal(prefix, network.PubKeyHashAddrID):
+		return hash, false, nil
+	case bytes.Equal(prefix, network.ScriptHashAddrID):
+		return hash, true, nil
+	}
+	return nil, false, fmt.Errorf("address %s does not belong to %s", s, network.Name)
+}
+
+// Returns raw Sapling payment address
+func (network *Network) DecodeSaplingAddress(s string) ([]byte, error) {
+	if len(network.SaplingHRP) == 0 {
+		return nil, fmt.Errorf("%s has no Sapling addresses", network.Name)
+	}
+	return address.DecodeSapling(s, network.SaplingHRP)
+}
+
+func (network *Network) DecodeUnifiedAddress(s string) ([]address.Receiver, error) {
+	if len(network.UnifiedHRP) == 0 {
+		return nil, fmt.Errorf("%s has no unified addresses", network.Name)
+	}
+	return address.DecodeUnified(s, network.UnifiedHRP)
+}
+
+var mainFoundersRewardAddresses = []string{
+	"t3Vz22vK5z2LcKEdg16Yv4FFneEL1zg9ojd",
+	"t3cL9AucCajm3HXDhb5jBnJK2vapVoXsop3",
+	"t3fqvkzrrNaMcamkQMwAyHRjfDdM2xQvDTR",
+	"t3TgZ9ZT2CTSK44AnUPi6qeNaHa2eC7pUyF",
+	"t3SpkcPQPfuRYHsP5vz3Pv86PgKo5m9KVmx",
+	"t3Xt4oQMRPagwbpQqkgAViQgtST4VoSWR6S",
+	"t3ayBkZ4w6kKXynwoHZFUSSgXRKtogTXNgb",
+	"t3adJBQuaa21u7NxbR8YMzp3km3TbSZ4MGB",
+	"t3K4aLYagSSBySdrfAGGeUd5H9z5Qvz88t2",
+	"t3RYnsc5nhEvKiva3ZPhfRSk7eyh1CrA6Rk",
+	"t3Ut4KUq2ZSMTPNE67pBU5LqYCi2q36KpXQ",
+	"t3ZnCNAvgu6CSyHm1vWtrx3aiN98dSAGpnD",
+	"t3fB9cB3eSYim64BS9xfwAHQUKLgQQroBDG",
+	"t3cwZfKNNj2vXMAHBQeewm6pXhKFdhk18kD",
+	"t3YcoujXfspWy7rbNUsGKxFEWZqNstGpeG4",
+	"t3bLvCLigc6rbNrUTS5NwkgyVrZcZumTRa4",
+	"t3VvHWa7r3oy67YtU4LZKGCWa2J6eGHvShi",
+	"t3eF9X6X2dSo7MCvTjfZEzwWrVzquxRLNeY",
+	"t3esCNwwmcyc8i9qQfyTbYhTqmYXZ9AwK3X",
+	"t3M4jN7hYE2e27yLsuQPPjuVek81WV3VbBj",
+	"t3gGWxdC67CYNoBbPjNvrrWLAWxPqZLxrVY",
+	"t3LTWeoxeWPbmdkUD3NWBquk4WkazhFBmvU",
+	"t3P5KKX97gXYFSaSjJPiruQEX84yF5z3Tjq",
+	"t3f3T3nCWsEpzmD35VK62JgQfFig74dV8C9",
+	"t3Rqonuzz7afkF7156ZA4vi4iimRSEn41hj",
+	"t3fJZ5jYsyxDtvNrWBeoMbvJaQCj4JJgbgX",
+	"t3Pnbg7XjP7FGPBUuz75H65aczphHgkpoJW",
+	"t3WeKQDxCijL5X7rwFem1MTL9ZwVJkUFhpF",
+	"t3Y9FNi26J7UtAUC4moaETLbMo8KS1Be6ME",
+	"t3aNRLLsL2y8xcjPheZZwFy3Pcv7CsTwBec",
+	"t3gQDEavk5VzAAHK8TrQu2BWDLxEiF1unBm",
+	"t3Rbykhx1TUFrgXrmBYrAJe2STxRKFL7G9r",
+	"t3aaW4aTdP7a8d1VTE1Bod2yhbeggHgMajR",
+	"t3YEiAa6uEjXwFL2v5ztU1fn3yKgzMQqNyo",
+	"t3g1yUUwt2PbmDvMDevTCPWUcbDatL2iQGP",
+	"t3dPWnep6YqGPuY1CecgbeZrY9iUwH8Yd4z",
+	"t3QRZXHDPh2hwU46iQs2776kRuuWfwFp4dV",
+	"t3enhACRxi1ZD7e8ePomVGKn7wp7N9fFJ3r",
+	"t3PkLgT71TnF112nSwBToXsD77yNbx2gJJY",
+	"t3LQtHUDoe7ZhhvddRv4vnaoNAhCr2f4oFN",
+	"t3fNcdBUbycvbCtsD2n9q3LuxG7jVPvFB8L",
+	"t3dKojUU2EMjs28nHV84TvkVEUDu1M1FaEx",
+	"t3aKH6NiWN1ofGd8c19rZiqgYpkJ3n679ME",
+	"t3MEXDF9Wsi63KwpPuQdD6by32Mw2bNTbEa",
+	"t3WDhPfik343yNmPTqtkZAoQZeqA83K7Y3f",
+	"t3PSn5TbMMAEw7Eu36DYctFezRzpX1hzf3M",
+	"t3R3Y5vnBLrEn8L6wFjPjBLnxSUQsKnmFpv",
+	"t3Pcm737EsVkGTbhsu2NekKtJeG92mvYyoN",
+}
+
+var testFoundersRewardAddresses = []string{
+	"t2UNzUUx8mWBCRYPRezvA363EYXyEpHokyi",
+	"t2N9PH9Wk9xjqYg9iin1Ua3aekJqfAtE543",
+	"t2NGQjYMQhFndDHguvUw4wZdNdsssA6K7x2",
+	"t2ENg7hHVqqs9JwU5cgjvSbxnT2a9USNfhy",
+	"t2BkYdVCHzvTJJUTx4yZB8qeegD8QsPx8bo",
+	"t2J8q1xH1EuigJ52MfExyyjYtN3VgvshKDf",
+	"t2Crq9mydTm37kZokC68HzT6yez3t2FBnFj",
+	"t2EaMPUiQ1kthqcP5UEkF42CAFKJqXCkXC9",
+	"t2F9dtQc63JDDyrhnfpzvVYTJcr57MkqA12",
+	"t2LPirmnfYSZc481GgZBa6xUGcoovfytBnC",
+	"t26xfxoSw2UV9Pe5o3C8V4YybQD4SESfxtp",
+	"t2D3k4fNdErd66YxtvXEdft9xuLoKD7CcVo",
+	"t2DWYBkxKNivdmsMiivNJzutaQGqmoRjRnL",
+	"t2C3kFF9iQRxfc4B9zgbWo4dQLLqzqjpuGQ",
+	"t2MnT5tzu9HSKcppRyUNwoTp8MUueuSGNaB",
+	"t2AREsWdoW1F8EQYsScsjkgqobmgrkKeUkK",
+	"t2Vf4wKcJ3ZFtLj4jezUUKkwYR92BLHn5UT",
+	"t2K3fdViH6R5tRuXLphKyoYXyZhyWGghDNY",
+	"t2VEn3KiKyHSGyzd3nDw6ESWtaCQHwuv9WC",
+	"t2F8XouqdNMq6zzEvxQXHV1TjwZRHwRg8gC",
+	"t2BS7Mrbaef3fA4xrmkvDisFVXVrRBnZ6Qj",
+	"t2FuSwoLCdBVPwdZuYoHrEzxAb9qy4qjbnL",
+	"t2SX3U8NtrT6gz5Db1AtQCSGjrpptr8JC6h",
+	"t2V51gZNSoJ5kRL74bf9YTtbZuv8Fcqx2FH",
+	"t2FyTsLjjdm4jeVwir4xzj7FAkUidbr1b4R",
+	"t2EYbGLekmpqHyn8UBF6kqpahrYm7D6N1Le",
+	"t2NQTrStZHtJECNFT3dUBLYA9AErxPCmkka",
+	"t2GSWZZJzoesYxfPTWXkFn5UaxjiYxGBU2a",
+	"t2RpffkzyLRevGM3w9aWdqMX6bd8uuAK3vn",
+	"t2JzjoQqnuXtTGSN7k7yk5keURBGvYofh1d",
+	"t2AEefc72ieTnsXKmgK2bZNckiwvZe3oPNL",
+	"t2NNs3ZGZFsNj2wvmVd8BSwSfvETgiLrD8J",
+	"t2ECCQPVcxUCSSQopdNquguEPE14HsVfcUn",
+	"t2JabDUkG8TaqVKYfqDJ3rqkVdHKp6hwXvG",
+	"t2FGzW5Zdc8Cy98ZKmRygsVGi6oKcmYir9n",
+	"t2DUD8a21FtEFn42oVLp5NGbogY13uyjy9t",
+	"t2UjVSd3zheHPgAkuX8WQW2CiC9xHQ8EvWp",
+	"t2TBUAhELyHUn8i6SXYsXz5Lmy7kDzA1uT5",
+	"t2Tz3uCyhP6eizUWDc3bGH7XUC9GQsEyQNc",
+	"t2NysJSZtLwMLWEJ6MH3BsxRh6h27mNcsSy",
+	"t2KXJVVyyrjVxxSeazbY9ksGyft4qsXUNm9",
+	"t2J9YYtH31cveiLZzjaE4AcuwVho6qjTNzp",
+	"t2QgvW4sP9zaGpPMH1GRzy7cpydmuRfB4AZ",
+	"t2NDTJP9MosKpyFPHJmfjc5pGCvAU58XGa4",
+	"t29pHDBWq7qN4EjwSEHg8wEqYe9pkmVrtRP",
+	"t2Ez9KM8VJLuArcxuEkNRAkhNvidKkzXcjJ",
+	"t2D5y7J5fpXajLbGrMBQkFg2mFN8fo3n8cX",
+	"t2UV2wr1PTaUiybpkV3FdSdGxUJeZdZztyt",
+}
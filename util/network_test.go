@@ -0,0 +1,202 @@
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFoundersRewardAddresses(t *testing.T) {
+	for _, network := range networks {
+		for _, address := range network.FoundersRewardAddresses {
+			if _, isScriptHash, err := network.DecodeTAddress(address); err != nil || !isScriptHash {
+				t.Errorf("Invalid %s founders address %s: %v", network.Name, address, err)
+			}
+		}
+	}
+}
+
+func TestIsValidLogin(t *testing.T) {
+	for _, login := range []string{"tmGoHHqgsCRuEna9YQX9zKp9ujeqGLMLEYi", "ztestsapling1kg3u0y7szv6509732at34alct46cyn0g26kppgf2a7h5tpqxldtwm7cmhf8rqmhgtmpakcz5mdv"} {
+		if !IsValidLogin(login) {
+			t.Errorf("Expected %s to be a valid login", login)
+		}
+	}
+	// Mainnet, bad checksum and arbitrary logins can't be paid on testnet
+	for _, login := range []string{"t1HsdDMzmJfq4vc7T17XYjEkLMLvbgM1fCi", "tmGoHHqgsCRuEna9YQX9zKp9ujeqGLMLEYj", "rig1"} {
+		if IsValidLogin(login) {
+			t.Errorf("Expected %s to be an invalid login", login)
+		}
+	}
+}
+
+func TestDecodeTAddress(t *testing.T) {
+	if _, _, err := TestNet.DecodeTAddress("tmGoHHqgsCRuEna9YQX9zKp9ujeqGLMLEYi"); err != nil {
+		t.Errorf("Expected testnet address to be valid: %v", err)
+	}
+	if _, _, err := MainNet.DecodeTAddress("tmGoHHqgsCRuEna9YQX9zKp9ujeqGLMLEYi"); err == nil {
+		t.Error("Expected testnet address to be rejected on mainnet")
+	}
+	if _, _, err := TestNet.DecodeTAddress("tmGoHHqgsCRuEna9YQX9zKp9ujeqGLMLEYj"); err == nil {
+		t.Error("Expected address with bad checksum to be rejected")
+	}
+}
+
+func TestDecodeSaplingAddress(t *testing.T) {
+	testAddress := "ztestsapling1kg3u0y7szv6509732at34alct46cyn0g26kppgf2a7h5tpqxldtwm7cmhf8rqmhgtmpakcz5mdv"
+	if data, err := TestNet.DecodeSaplingAddress(testAddress); err != nil || len(data) != 43 {
+		t.Errorf("Expected testnet Sapling address to be valid: %v", err)
+	}
+	mainAddress := "zs1qqqsyqcyq5rqwzqfpg9scrgwpugpzysnzs23v9ccrydpk8qarc0jqgfzyvjz2f389q5j5ctfvp5"
+	data, err := MainNet.DecodeSaplingAddress(mainAddress)
+	if err != nil {
+		t.Fatalf("Expected mainnet Sapling address to be valid: %v", err)
+	}
+	for i, b := range data {
+		if int(b) != i {
+			t.Fatalf("Unexpected address data %x", data)
+		}
+	}
+	if _, err := MainNet.DecodeSaplingAddress(testAddress); err == nil {
+		t.Error("Expected testnet address to be rejected on mainnet")
+	}
+	if _, err := MainNet.DecodeSaplingAddress(mainAddress[:len(mainAddress)-1] + "q"); err == nil {
+		t.Error("Expected address with bad checksum to be rejected")
+	}
+	if !IsValidLogin(testAddress) || !IsValidAddress(testAddress) || IsValidtAddress(testAddress) {
+		t.Error("Expected testnet Sapling address to be a valid login and payout address")
+	}
+	if login := NormalizeLogin(strings.ToUpper(testAddress)); login != testAddress {
+		t.Errorf("Expected uppercase Sapling login to be normalized, got %s", login)
+	}
+}
+
+func TestDecodeUnifiedAddress(t *testing.T) {
+	testAddress := "utest1cvanrhfp7hhjq2nymm7fatse8tv2kn96leewnsqrrcmj8p6g6chuua39wjhx9exxdlga3zhjkqztl6asxnqjc34txwk47cw299w8n05k9dpxhts2wvvpqa97tqzz08c337y80pjuhrjlqz49tnapgc57y0w09vepg0n0zsjjlhpu2l8arq8hhuyduf5s7fjs74z52nlufmfzgy3rk03"
+	if _, err := TestNet.DecodeUnifiedAddress(testAddress); err != nil {
+		t.Errorf("Expected testnet unified address to be valid: %v", err)
+	}
+	if _, err := MainNet.DecodeUnifiedAddress(testAddress); err == nil {
+		t.Error("Expected testnet address to be rejected on mainnet")
+	}
+	if !IsValidLogin(testAddress) || !IsValidAddress(testAddress) || IsValidzAddress(testAddress) {
+		t.Error("Expected testnet unified address to be a valid login and payout address")
+	}
+}
+
+func TestParseZec(t *testing.T) {
+	tests := []struct {
+		amount string
+		want   int64
+	}{
+		{"6.25", 625000000},
+		{"0.00000001", 1},
+		{"-0.0001", -10000},
+	}
+	for _, test := range tests {
+		if zatoshi, err := ParseZec(test.amount); err != nil || zatoshi != test.want {
+			t.Errorf("ParseZec(%s): got %d, want %d: %v", test.amount, zatoshi, test.want, err)
+		}
+	}
+	if _, err := ParseZec("0.000000001"); err == nil {
+		t.Error("Expected fraction of zatoshi to be rejected")
+	}
+}
+
+func TestMinerReward(t *testing.T) {
+	table := []struct {
+		network *Network
+		height  int64
+		reward  int64
+	}{
+		{MainNet, 1, 50000},
+		{MainNet, 20000, 1000000000},
+		{MainNet, 653599, 1000000000},
+		{MainNet, 653600, 500000000},
+		{MainNet, 1046399, 500000000},
+		{MainNet, 1046400, 250000000},
+		{MainNet, 2726400, 125000000},
+		{MainNet, 3146400, 156250000},
+		{TestNet, 2800000, 156250000},
+		{RegTest, 143, 1000000000},
+		{RegTest, 144, 625000000},
+	}
+
+	for _, v := range table {
+		if reward := v.network.MinerReward(v.height); reward != v.reward {
+			t.Errorf("Miner reward on %s at %d: expected %d, got %d", v.network.Name, v.height, v.reward, reward)
+		}
+	}
+}
+
+func TestFoundersRewardAddress(t *testing.T) {
+	table := []struct {
+		network *Network
+		height  int64
+		address string
+	}{
+		{MainNet, 1, "t3Vz22vK5z2LcKEdg16Yv4FFneEL1zg9ojd"},
+		{MainNet, 17709, "t3cL9AucCajm3HXDhb5jBnJK2vapVoXsop3"},
+		{MainNet, 1046399, "t3Pcm737EsVkGTbhsu2NekKtJeG92mvYyoN"},
+		{TestNet, 1, "t2UNzUUx8mWBCRYPRezvA363EYXyEpHokyi"},
+	}
+
+	for _, v := range table {
+		if address := v.network.FoundersRewardAddress(v.height); address != v.address {
+			t.Errorf("Founders address on %s at %d: expected %s, got %s", v.network.Name, v.height, v.address, address)
+		}
+	}
+}
+
+func TestLoadCoin(t *testing.T) {
+	defer SetNetwork("testnet")
+
+	if err := SetNetwork("mainnet"); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadCoin("../coinConfig.json"); err != nil {
+		t.Fatal(err)
+	}
+
+	network := ActiveNetwork()
+	if network.Name != "zcash_testnet" || network.EquihashPersonalization != "ZcashPoW" {
+		t.Errorf("Unexpected coin parameters %+v", network)
+	}
+	if !IsValidtAddress("tmGoHHqgsCRuEna9YQX9zKp9ujeqGLMLEYi") {
+		t.Error("Expected testnet address to be valid for loaded coin")
+	}
+	if reward := GetConstReward(20000).Int64(); reward != 1000000000 {
+		t.Errorf("Expected loaded coin miner reward of 1000000000, got %d", reward)
+	}
+}
+
+func TestLoadCoinFoundersPercent(t *testing.T) {
+	defer SetNetwork("testnet")
+
+	dir, err := ioutil.TempDir("", "coin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "coin.json")
+	coin := `{"name": "fork", "payFoundersReward": true, "percentFoundersReward": 10}`
+	if err := ioutil.WriteFile(fileName, []byte(coin), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := SetNetwork("mainnet"); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadCoin(fileName); err != nil {
+		t.Fatal(err)
+	}
+	network := ActiveNetwork()
+	if reward := network.FoundersReward(20000); reward != 125000000 {
+		t.Errorf("Expected 10%% founders reward of 125000000, got %d", reward)
+	}
+	if reward := GetConstReward(20000).Int64(); reward != 1125000000 {
+		t.Errorf("Expected miner reward of 1125000000, got %d", reward)
+	}
+}
@@ -6,8 +6,8 @@ import (
 	"encoding/hex"
 	"errors"
 	"math/big"
-	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
@@ -16,75 +16,44 @@ import (
 
 var Zcash = math.BigPow(10, 8)
 
-var PowLimitMain = new(big.Int).Sub(math.BigPow(2, 243), big.NewInt(1))
-var PowLimitTest = new(big.Int).Sub(math.BigPow(2, 251), big.NewInt(1))
 var pow256 = math.BigPow(2, 256)
 
-const FirstRewardHalvingBlock = 840000
-const pre840Reward int64 = 1000000000
-const post840Reward int64 = 625000000
-
-var tAddressPattern = regexp.MustCompile("^t[0-9a-zA-Z]{34}$")
-var loginPattern = regexp.MustCompile("^[[:alnum:]]{1,40}$")
-
-var FoundersRewardAddressChangeInterval = 17709.3125
-var TestFoundersRewardAddresses = [48]string{
-	"t2UNzUUx8mWBCRYPRezvA363EYXyEpHokyi",
-	"t2N9PH9Wk9xjqYg9iin1Ua3aekJqfAtE543",
-	"t2NGQjYMQhFndDHguvUw4wZdNdsssA6K7x2",
-	"t2ENg7hHVqqs9JwU5cgjvSbxnT2a9USNfhy",
-	"t2BkYdVCHzvTJJUTx4yZB8qeegD8QsPx8bo",
-	"t2J8q1xH1EuigJ52MfExyyjYtN3VgvshKDf",
-	"t2Crq9mydTm37kZokC68HzT6yez3t2FBnFj",
-	"t2EaMPUiQ1kthqcP5UEkF42CAFKJqXCkXC9",
-	"t2F9dtQc63JDDyrhnfpzvVYTJcr57MkqA12",
-	"t2LPirmnfYSZc481GgZBa6xUGcoovfytBnC",
-	"t26xfxoSw2UV9Pe5o3C8V4YybQD4SESfxtp",
-	"t2D3k4fNdErd66YxtvXEdft9xuLoKD7CcVo",
-	"t2DWYBkxKNivdmsMiivNJzutaQGqmoRjRnL",
-	"t2C3kFF9iQRxfc4B9zgbWo4dQLLqzqjpuGQ",
-	"t2MnT5tzu9HSKcppRyUNwoTp8MUueuSGNaB",
-	"t2AREsWdoW1F8EQYsScsjkgqobmgrkKeUkK",
-	"t2Vf4wKcJ3ZFtLj4jezUUKkwYR92BLHn5UT",
-	"t2K3fdViH6R5tRuXLphKyoYXyZhyWGghDNY",
-	"t2VEn3KiKyHSGyzd3nDw6ESWtaCQHwuv9WC",
-	"t2F8XouqdNMq6zzEvxQXHV1TjwZRHwRg8gC",
-	"t2BS7Mrbaef3fA4xrmkvDisFVXVrRBnZ6Qj",
-	"t2FuSwoLCdBVPwdZuYoHrEzxAb9qy4qjbnL",
-	"t2SX3U8NtrT6gz5Db1AtQCSGjrpptr8JC6h",
-	"t2V51gZNSoJ5kRL74bf9YTtbZuv8Fcqx2FH",
-	"t2FyTsLjjdm4jeVwir4xzj7FAkUidbr1b4R",
-	"t2EYbGLekmpqHyn8UBF6kqpahrYm7D6N1Le",
-	"t2NQTrStZHtJECNFT3dUBLYA9AErxPCmkka",
-	"t2GSWZZJzoesYxfPTWXkFn5UaxjiYxGBU2a",
-	"t2RpffkzyLRevGM3w9aWdqMX6bd8uuAK3vn",
-	"t2JzjoQqnuXtTGSN7k7yk5keURBGvYofh1d",
-	"t2AEefc72ieTnsXKmgK2bZNckiwvZe3oPNL",
-	"t2NNs3ZGZFsNj2wvmVd8BSwSfvETgiLrD8J",
-	"t2ECCQPVcxUCSSQopdNquguEPE14HsVfcUn",
-	"t2JabDUkG8TaqVKYfqDJ3rqkVdHKp6hwXvG",
-	"t2FGzW5Zdc8Cy98ZKmRygsVGi6oKcmYir9n",
-	"t2DUD8a21FtEFn42oVLp5NGbogY13uyjy9t",
-	"t2UjVSd3zheHPgAkuX8WQW2CiC9xHQ8EvWp",
-	"t2TBUAhELyHUn8i6SXYsXz5Lmy7kDzA1uT5",
-	"t2Tz3uCyhP6eizUWDc3bGH7XUC9GQsEyQNc",
-	"t2NysJSZtLwMLWEJ6MH3BsxRh6h27mNcsSy",
-	"t2KXJVVyyrjVxxSeazbY9ksGyft4qsXUNm9",
-	"t2J9YYtH31cveiLZzjaE4AcuwVho6qjTNzp",
-	"t2QgvW4sP9zaGpPMH1GRzy7cpydmuRfB4AZ",
-	"t2NDTJP9MosKpyFPHJmfjc5pGCvAU58XGa4",
-	"t29pHDBWq7qN4EjwSEHg8wEqYe9pkmVrtRP",
-	"t2Ez9KM8VJLuArcxuEkNRAkhNvidKkzXcjJ",
-	"t2D5y7J5fpXajLbGrMBQkFg2mFN8fo3n8cX",
-	"t2UV2wr1PTaUiybpkV3FdSdGxUJeZdZztyt",
+func IsValidtAddress(s string) bool {
+	_, _, err := activeNetwork.DecodeTAddress(s)
+	return err == nil
 }
 
-func IsValidtAddress(s string) bool {
-	return tAddressPattern.MatchString(s)
+func IsValidzAddress(s string) bool {
+	_, err := activeNetwork.DecodeSaplingAddress(s)
+	return err == nil
+}
+
+func IsValidUnifiedAddress(s string) bool {
+	_, err := activeNetwork.DecodeUnifiedAddress(s)
+	return err == nil
+}
+
+// Address z_sendmany pays to shielded receiver
+func IsValidShieldedAddress(s string) bool {
+	return IsValidzAddress(s) || IsValidUnifiedAddress(s)
+}
+
+// Payout address of any kind
+func IsValidAddress(s string) bool {
+	return IsValidtAddress(s) || IsValidShieldedAddress(s)
 }
 
+// Logins are payout addresses of the active network, anything else could never be paid
 func IsValidLogin(s string) bool {
-	return loginPattern.MatchString(s)
+	return IsValidAddress(s)
+}
+
+// Bech32 addresses are case insensitive, lowercase one keys miner in redis
+func NormalizeLogin(s string) string {
+	if IsValidShieldedAddress(s) {
+		return strings.ToLower(s)
+	}
+	return s
 }
 
 func MakeTimestamp() int64 {
@@ -94,7 +63,7 @@ func MakeTimestamp() int64 {
 func GetTargetHex(diff int64) string {
 	var result [32]uint8
 	difficulty := big.NewInt(diff)
-	bytes := new(big.Int).Div(PowLimitTest, difficulty).Bytes()
+	bytes := new(big.Int).Div(activeNetwork.PowLimit, difficulty).Bytes()
 	copy(result[len(result)-len(bytes):], bytes)
 
 	return BytesToHex(result[:])
@@ -119,6 +88,19 @@ func FormatRatReward(reward *big.Rat) string {
 	return reward.FloatString(8)
 }
 
+// Zatoshi of decimal ZEC amount returned by the node
+func ParseZec(amount string) (int64, error) {
+	value, ok := new(big.Rat).SetString(amount)
+	if !ok {
+		return 0, errors.New("malformed amount " + amount)
+	}
+	value.Mul(value, new(big.Rat).SetInt(Zcash))
+	if !value.IsInt() {
+		return 0, errors.New("amount " + amount + " is not a whole number of zatoshi")
+	}
+	return value.Num().Int64(), nil
+}
+
 func StringInSlice(a string, list []string) bool {
 	for _, b := range list {
 		if b == a {
@@ -176,6 +158,19 @@ func PackUInt64LE(num uint64) []byte {
 	return b
 }
 
+// Bitcoin compact size encoding
+func PackVarInt(num uint64) []byte {
+	switch {
+	case num < 0xfd:
+		return []byte{byte(num)}
+	case num <= 0xffff:
+		return append([]byte{0xfd}, PackUInt16LE(uint16(num))...)
+	case num <= 0xffffffff:
+		return append([]byte{0xfe}, PackUInt32LE(uint32(num))...)
+	}
+	return append([]byte{0xff}, PackUInt64LE(num)...)
+}
+
 func PackUInt16BE(num uint16) []byte {
 	b := make([]byte, 2)
 	binary.BigEndian.PutUint16(b, num)
@@ -240,11 +235,9 @@ func Sha256d(decrypted []byte) [32]byte {
 }
 
 func GetConstReward(height int64) *big.Int {
-	if height >= FirstRewardHalvingBlock {
-		return big.NewInt(post840Reward)
-	}
-	return big.NewInt(pre840Reward)
+	return big.NewInt(activeNetwork.MinerReward(height))
 }
+
 func CreateExtraNonceCounter(seed uint32) uint32 {
 	return seed << 27
 }